@@ -1,12 +1,17 @@
+//go:build windows
+
 package main
 
 import (
+	"encoding/xml"
+	"errors"
 	"flag"
 	"fmt"
 	"github.com/w4l1dcode/GoPersist/pkg/persist"
 	"golang.org/x/sys/windows"
 	"log"
 	"os"
+	"strings"
 )
 
 func main() {
@@ -18,42 +23,98 @@ func main() {
 		schCommand  string
 		schArgs     string
 		trigger     string
+		schXML      string
+		schUser     string
+		schGroup    string
+		schPassword string
+		schLogon    string
+		schRunLevel string
+		schSettings string
+		schWorkDir  string
+		schActions  actionList
+		schCleanup  bool
+		schParams   string
+		schRecurse  bool
 		startupCmd  string
 		startupArgs string
+		startupAll  bool
 		serviceName string
 		serviceDesc string
+		svcDisplay  string
+		svcStart    string
+		svcAccount  string
+		svcPassword string
+		svcDepends  string
+		svcGroup    string
+		svcError    string
+		svcHost     bool
+		svcRecovery string
+		svcTriggers triggerList
 		servicePath string
 		serviceArgs string
 		regKey      string
 		regValue    string
 		regCmd      string
 		regArgs     string
+		regHive     string
+		regPreset   string
+		regType     string
+		regAppend   bool
+		regSep      string
 	)
 
 	// Define flags for the technique to use
-	flag.StringVar(&technique, "t", "", "Technique to use: 'schtask', 'service', 'reg', 'startup'")
+	flag.StringVar(&technique, "t", "", "Technique to use: 'schtask', 'service', 'reg', 'startup', or 'preflight' to report the privileges GoPersist runs with")
 
 	// Define flags for action (add or remove)
-	flag.StringVar(&action, "action", "", "Action: 'add' to add or 'remove' to delete")
+	flag.StringVar(&action, "action", "", "Action: 'add' to add or 'remove' to delete; for schtask also 'export' to write the task as XML, 'run', 'stop', 'enable', 'disable', 'status' or 'list'")
 
 	// Flags for scheduled task technique
 	flag.StringVar(&schCommand, "sch-cmd", "", "Command for the scheduled task")
 	flag.StringVar(&schArgs, "sch-args", "", "Arguments for the scheduled task")
-	flag.StringVar(&taskName, "sch-name", "", "File name for the scheduled task")
-	flag.StringVar(&trigger, "trigger", "", "Trigger for the scheduled task")
+	flag.StringVar(&taskName, "sch-name", "", "Name of the scheduled task, optionally with a folder path (e.g. '\\Acme\\Updater\\Check')")
+	flag.StringVar(&trigger, "trigger", "", "Triggers for the scheduled task, ';' separated (e.g. 'daily at=09:00; logon'); see README for all triggers and parameters")
+	flag.StringVar(&schXML, "sch-xml", "", "Task Scheduler XML file to import on add, or to write on export")
+	flag.StringVar(&schUser, "sch-user", "", "Account the scheduled task runs as: 'SYSTEM', 'LocalService', 'NetworkService' or 'DOMAIN\\user' (default current user)")
+	flag.StringVar(&schGroup, "sch-group", "", "Group the scheduled task runs for, instead of a user")
+	flag.StringVar(&schPassword, "sch-password", "", "Password of -sch-user for password logon")
+	flag.StringVar(&schLogon, "sch-logon", "", "Logon type for -sch-user: 'password', 's4u', 'interactive' or 'interactive-or-password'")
+	flag.StringVar(&schRunLevel, "sch-runlevel", "", "Run level of the scheduled task: 'lua' (default) or 'highest'")
+	flag.StringVar(&schWorkDir, "sch-workdir", "", "Working directory of the scheduled task command")
+	flag.Var(&schActions, "sch-action", "Additional scheduled task action, repeatable: 'exec cmd=<path> [args=<args>] [dir=<dir>]' or 'com class=<CLSID> [data=<data>]'")
+	flag.StringVar(&schParams, "sch-params", "", "Comma separated parameters passed to the scheduled task on run, as $(Arg0), $(Arg1), ...")
+	flag.BoolVar(&schCleanup, "sch-cleanup", false, "On remove, also delete the task's folders that GoPersist created once they are empty")
+	flag.BoolVar(&schRecurse, "sch-recursive", false, "On list, also list the tasks in subfolders of -sch-name")
+	flag.StringVar(&schSettings, "sch-settings", "", "Settings of the scheduled task as key=value pairs (e.g. 'restart-count=3 restart-interval=5m limit=none'); see README")
 
 	// Flags for startup technique
 	flag.StringVar(&startupCmd, "startup-cmd", "", "Command (path) for the startup entry")
 	flag.StringVar(&startupArgs, "startup-args", "", "Arguments for the startup command")
+	flag.BoolVar(&startupAll, "startup-all-users", false, "Use the Startup folder of all users instead of the current user's (requires elevation)")
 
 	// Flags for service technique
 	flag.StringVar(&serviceName, "svc-name", "", "Name of the service")
 	flag.StringVar(&serviceDesc, "svc-desc", "", "Description of the service")
+	flag.StringVar(&svcDisplay, "svc-display", "", "Display name of the service (default -svc-name)")
+	flag.StringVar(&svcStart, "svc-start", "", "Start type of the service: 'auto' (default), 'delayed-auto', 'demand' or 'disabled'")
+	flag.StringVar(&svcAccount, "svc-account", "", "Account the service runs as: 'LocalSystem' (default), 'LocalService', 'NetworkService', 'virtual' or 'DOMAIN\\user'")
+	flag.StringVar(&svcPassword, "svc-password", "", "Password of -svc-account for user accounts")
+	flag.StringVar(&svcDepends, "svc-depends", "", "Comma separated services the service depends on; prefix load order groups with '+'")
+	flag.StringVar(&svcGroup, "svc-group", "", "Load order group of the service")
+	flag.BoolVar(&svcHost, "svc-host", false, "Run -svc-path through GoPersist's built-in service host, for commands that are not Windows services")
+	flag.StringVar(&svcRecovery, "svc-recovery", "", "Recovery of the service on failure as key=value pairs (e.g. 'restart=5s restart=1m reset=1d'); see README")
+	flag.Var(&svcTriggers, "svc-trigger", "Event that starts the service, repeatable: 'network', 'network-lost', 'domain-join', 'domain-leave', 'policy scope=machine|user', 'device class=<GUID> [id=<hardware id>]' or 'etw provider=<GUID> [keyword=<n>] [level=<n>]'; see README")
+	flag.StringVar(&svcError, "svc-error", "", "Error control of the service: 'ignore', 'normal' (default), 'severe' or 'critical'")
 	flag.StringVar(&servicePath, "svc-path", "", "Path to the executable for the service")
 	flag.StringVar(&serviceArgs, "svc-args", "", "Arguments for the service")
 
 	// Flags for registry technique
-	flag.StringVar(&regKey, "reg-key", "", "Registry key path")
+	flag.StringVar(&regKey, "reg-key", "", "Registry key path, optionally starting with its hive, e.g. 'HKLM\\Software\\...'")
+	flag.StringVar(&regPreset, "reg-preset", "", "Registry autostart preset instead of -reg-key, e.g. 'run', 'runonce' or 'winlogon-userinit'; '-t reg -action list' lists them")
+	flag.StringVar(&regType, "reg-type", "", "Registry value type: 'sz' (default), 'expand_sz' or 'multi_sz'")
+	flag.BoolVar(&regAppend, "reg-append", false, "Append the command as an entry to an existing list value instead of replacing it; remove strips only that entry")
+	flag.StringVar(&regSep, "reg-sep", ",", "Separator of the entries of an appended sz or expand_sz value, e.g. ',' or ' '")
+	flag.StringVar(&regHive, "reg-hive", "", "Registry hive: 'HKCU' (default), 'HKLM' or 'HKU\\<SID>'")
 	flag.StringVar(&regValue, "reg-val", "", "Registry value name")
 	flag.StringVar(&regCmd, "reg-cmd", "", "Path to the executable or PowerShell command for registry persistence")
 	flag.StringVar(&regArgs, "reg-args", "", "Arguments for the PowerShell command")
@@ -68,33 +129,163 @@ func main() {
 		os.Exit(1)
 	}
 
-	if action != "add" && action != "remove" {
-		fmt.Println("Error: Action must be 'add' or 'remove'.")
+	if technique == "preflight" {
+		report, err := persist.CheckPreflight()
+		if err != nil {
+			log.Fatalf("Preflight check failed: %v", err)
+		}
+		fmt.Println(report)
+		return
+	}
+
+	schtaskActions := map[string]bool{"export": true, "run": true, "stop": true, "enable": true, "disable": true, "status": true, "list": true}
+	if action != "add" && action != "remove" && !(technique == "schtask" && schtaskActions[action]) && !(technique == "service" && (action == "modify" || action == "host" || action == "status")) && !(technique == "reg" && action == "list") {
+		fmt.Println("Error: Action must be 'add' or 'remove' (schtask also supports 'export', 'run', 'stop', 'enable', 'disable', 'status' and 'list'; service also 'modify', 'status' and 'host'; reg also 'list').")
 		flag.Usage()
 		os.Exit(1)
 	}
 
 	switch technique {
 	case "schtask":
+		// Build the task principal from the principal flags, if any
+		var principal *persist.TaskPrincipal
+		if schUser != "" || schGroup != "" || schPassword != "" || schLogon != "" || schRunLevel != "" {
+			var err error
+			principal, err = persist.NewTaskPrincipal(schUser, schGroup, schPassword, schLogon, schRunLevel)
+			if err != nil {
+				log.Fatalf("Invalid task principal: %v", err)
+			}
+		}
+
+		// Parse the task settings, if any
+		var settings *persist.TaskSettings
+		if schSettings != "" {
+			var err error
+			settings, err = persist.ParseTaskSettings(schSettings)
+			if err != nil {
+				log.Fatalf("Invalid task settings: %v", err)
+			}
+		}
+
 		if action == "add" {
-			if schCommand == "" || schArgs == "" || taskName == "" {
-				log.Println("Error: -sch-cmd (command), -sch-args (arguments), and -file (file-name) are required for adding a scheduled task.")
+			if taskName == "" || (schXML == "" && len(schActions) == 0 && (schCommand == "" || schArgs == "")) {
+				log.Println("Error: -sch-name (task-name) and either -sch-xml (xml-file), -sch-action (action) or -sch-cmd (command) and -sch-args (arguments) are required for adding a scheduled task.")
 				flag.Usage()
 				os.Exit(1)
 			}
-			task := persist.NewSchTask(taskName, schCommand, schArgs, trigger)
+			task := persist.NewSchTask(taskName, schCommand, persist.SplitArgs(schArgs), trigger)
+			if schXML != "" {
+				// The imported definition has its own triggers and command;
+				// principal, settings and extra actions are applied to it
+				if trigger != "" || schCommand != "" || schArgs != "" || schWorkDir != "" {
+					log.Println("Error: -trigger, -sch-cmd, -sch-args and -sch-workdir are given by the -sch-xml definition.")
+					flag.Usage()
+					os.Exit(1)
+				}
+				var err error
+				task, err = persist.NewSchTaskFromXML(taskName, schXML)
+				if err != nil {
+					log.Fatalf("Failed to import task: %v", err)
+				}
+			}
+			task.SetPrincipal(principal)
+			task.SetSettings(settings)
+			task.SetWorkingDirectory(schWorkDir)
+			for _, a := range schActions {
+				task.AddAction(a)
+			}
 			err := task.CreateTask()
 			if err != nil {
 				log.Fatalf("Failed to create task: %v", err)
 			}
 			log.Println("Scheduled task created successfully.")
+		} else if action == "export" {
+			if (schCommand == "" && len(schActions) == 0) || taskName == "" {
+				log.Println("Error: -sch-cmd (command) or -sch-action (action), and -sch-name (task-name) are required for exporting a scheduled task.")
+				flag.Usage()
+				os.Exit(1)
+			}
+			task := persist.NewSchTask(taskName, schCommand, persist.SplitArgs(schArgs), trigger)
+			task.SetPrincipal(principal)
+			task.SetSettings(settings)
+			task.SetWorkingDirectory(schWorkDir)
+			for _, a := range schActions {
+				task.AddAction(a)
+			}
+			taskXML, err := task.XML()
+			if err != nil {
+				log.Fatalf("Failed to render task: %v", err)
+			}
+			if schXML == "" {
+				fmt.Println(xml.Header + taskXML)
+				return
+			}
+			err = os.WriteFile(schXML, []byte(xml.Header+taskXML), 0644)
+			if err != nil {
+				log.Fatalf("Failed to write task XML: %v", err)
+			}
+			log.Printf("Scheduled task XML written to %s.", schXML)
 		} else if action == "remove" {
-			task := persist.NewSchTask(taskName, "", "", "")
+			if taskName == "" {
+				log.Println("Error: -sch-name (task-name) is required for removing a scheduled task.")
+				flag.Usage()
+				os.Exit(1)
+			}
+			task := persist.NewSchTask(taskName, "", nil, "")
+			task.SetRemoveEmptyFolders(schCleanup)
 			err := task.RemoveTask()
 			if err != nil {
 				log.Fatalf("Failed to remove task: %v", err)
 			}
 			log.Println("Scheduled task removed successfully.")
+		} else if action == "list" {
+			ts, err := persist.NewTaskScheduler()
+			if err != nil {
+				log.Fatalf("Failed to connect to Task Scheduler: %v", err)
+			}
+			defer ts.Close()
+
+			// -sch-name is the folder to list, the root folder by default
+			statuses, err := ts.List(taskName, schRecurse)
+			if err != nil {
+				log.Fatalf("Failed to list tasks: %v", err)
+			}
+			for _, status := range statuses {
+				fmt.Println(status)
+			}
+		} else {
+			if taskName == "" {
+				log.Printf("Error: -sch-name (task-name) is required to %s a scheduled task.", action)
+				flag.Usage()
+				os.Exit(1)
+			}
+			ts, err := persist.NewTaskScheduler()
+			if err != nil {
+				log.Fatalf("Failed to connect to Task Scheduler: %v", err)
+			}
+			defer ts.Close()
+
+			switch action {
+			case "run":
+				var params []string
+				if schParams != "" {
+					params = strings.Split(schParams, ",")
+				}
+				err = ts.Run(taskName, params)
+			case "stop":
+				err = ts.Stop(taskName)
+			case "enable", "disable":
+				err = ts.SetEnabled(taskName, action == "enable")
+			}
+			if err != nil {
+				log.Fatalf("Failed to %s task: %v", action, err)
+			}
+
+			status, err := ts.Status(taskName)
+			if err != nil {
+				log.Fatalf("Failed to query task: %v", err)
+			}
+			fmt.Println(status)
 		}
 
 	case "startup":
@@ -105,13 +296,13 @@ func main() {
 				os.Exit(1)
 			}
 			if startupArgs != "" {
-				err := persist.CreateStartupBatchFile(startupCmd, startupArgs, taskName)
+				err := persist.CreateStartupBatchFile(startupCmd, persist.SplitArgs(startupArgs), taskName, startupAll)
 				if err != nil {
 					log.Fatalf("Error creating startup entry: %v", err)
 				}
 				log.Println("Startup entry created successfully.")
 			} else {
-				err := persist.DropFileToStartup(startupCmd, taskName)
+				err := persist.DropFileToStartup(startupCmd, taskName, startupAll)
 				if err != nil {
 					log.Fatalf("Error dropping file to startup: %v", err)
 				}
@@ -122,7 +313,7 @@ func main() {
 				flag.Usage()
 				os.Exit(1)
 			}
-			err := persist.RemoveFileFromStartup(taskName)
+			err := persist.RemoveFileFromStartup(taskName, startupAll)
 			if err != nil {
 				log.Fatalf("Error removing file from Startup: %v", err)
 			}
@@ -130,48 +321,129 @@ func main() {
 		}
 
 	case "service":
-		// Check if user is admin
-		var sid *windows.SID
-		err := windows.AllocateAndInitializeSid(&windows.SECURITY_NT_AUTHORITY, 2, windows.SECURITY_BUILTIN_DOMAIN_RID, windows.DOMAIN_ALIAS_RID_ADMINS, 0, 0, 0, 0, 0, 0, &sid)
-		if err != nil {
-			panic(err)
-		}
-
-		token, err := persist.OpenCurrentThreadToken()
-		if err != nil {
-			panic(err)
-		}
-
-		member, err := token.IsMember(sid)
-		if err != nil {
-			panic(err)
+		if action == "host" {
+			// Started by the SCM as the binary of a service added with -svc-host
+			err := persist.RunServiceHost(serviceName, servicePath, persist.SplitArgs(serviceArgs))
+			if err != nil {
+				log.Fatalf("Error hosting service: %v", err)
+			}
+			return
 		}
-		if member == false {
-			log.Fatalf("You need admin permissions to create a service.")
+		if action == "status" {
+			if serviceName == "" {
+				log.Println("Error: -svc-name (service-name) is required to show the status of a service.")
+				flag.Usage()
+				os.Exit(1)
+			}
+			info, err := persist.QueryService(serviceName)
+			if err != nil {
+				log.Fatalf("Failed to query service: %v", err)
+			}
+			fmt.Println(info)
+			return
 		}
 
+		var err error
 		if action == "add" {
-			if serviceName == "" || serviceDesc == "" || servicePath == "" {
-				log.Println("Error: -svc-name (service-name), -svc-desc (description), and -svc-path (executable-path) are required for adding a service.")
+			if serviceName == "" || servicePath == "" {
+				log.Println("Error: -svc-name (service-name) and -svc-path (executable-path) are required for adding a service.")
 				flag.Usage()
 				os.Exit(1)
 			}
-			err := persist.CreateService(serviceName, serviceDesc, servicePath, serviceArgs)
+			if svcDisplay == "" {
+				svcDisplay = serviceName
+			}
+			if len(svcTriggers) > 0 && svcStart == "" {
+				// Trigger started services are started on demand
+				svcStart = "demand"
+			}
+			opts, err := persist.NewServiceOptions(serviceName, svcDisplay, serviceDesc, svcStart, svcAccount, svcPassword, svcDepends, svcGroup, svcError)
+			if err != nil {
+				log.Fatalf("Invalid service options: %v", err)
+			}
+			if svcRecovery != "" {
+				opts.Recovery, err = persist.ParseServiceRecovery(svcRecovery)
+				if err != nil {
+					log.Fatalf("Invalid service recovery: %v", err)
+				}
+			}
+			opts.Triggers = svcTriggers
+			binaryPath, binaryArgs := servicePath, persist.SplitArgs(serviceArgs)
+			if svcHost {
+				// Register GoPersist itself as the service binary, hosting the command
+				binaryPath, err = os.Executable()
+				if err != nil {
+					log.Fatalf("Failed to locate GoPersist executable: %v", err)
+				}
+				binaryArgs = []string{"-t", "service", "-action", "host", "-svc-name", serviceName, "-svc-path", servicePath, "-svc-args", serviceArgs}
+			}
+			err = persist.CreateService(serviceName, binaryPath, binaryArgs, opts)
 			if err != nil {
 				log.Fatalf("Error creating service: %v", err)
 			}
 			log.Println("Service created successfully.")
+			if strings.EqualFold(svcStart, "disabled") {
+				return
+			}
 			err = persist.StartService(serviceName)
+			if errors.Is(err, windows.ERROR_SERVICE_REQUEST_TIMEOUT) && !svcHost {
+				log.Fatalf("Error starting service: %v; if -svc-path is not a Windows service, add -svc-host", err)
+			}
 			if err != nil {
 				log.Fatalf("Error starting service: %v", err)
 			}
+		} else if action == "modify" {
+			if serviceName == "" {
+				log.Println("Error: -svc-name (service-name) is required for modifying a service.")
+				flag.Usage()
+				os.Exit(1)
+			}
+			changes := persist.ServiceChanges{
+				ExecutablePath: servicePath,
+				Args:           persist.SplitArgs(serviceArgs),
+				DisplayName:    svcDisplay,
+				Description:    serviceDesc,
+				StartType:      svcStart,
+				Account:        svcAccount,
+				Password:       svcPassword,
+			}
+			if svcHost {
+				if servicePath == "" {
+					log.Fatalf("-svc-host requires -svc-path")
+				}
+				// Repoint the service at GoPersist itself, hosting the command
+				changes.ExecutablePath, err = os.Executable()
+				if err != nil {
+					log.Fatalf("Failed to locate GoPersist executable: %v", err)
+				}
+				changes.Args = []string{"-t", "service", "-action", "host", "-svc-name", serviceName, "-svc-path", servicePath, "-svc-args", serviceArgs}
+			}
+			err = persist.ModifyService(serviceName, changes)
+			if err != nil {
+				log.Fatalf("Error modifying service: %v", err)
+			}
+			log.Println("Service modified successfully. Use -action remove to restore its original configuration.")
 		} else if action == "remove" {
 			if serviceName == "" {
 				log.Println("Error: -svc-name (service-name) is required for deleting a service.")
 				flag.Usage()
 				os.Exit(1)
 			}
-			err := persist.DeleteService(serviceName)
+
+			// A service GoPersist modified is restored rather than deleted
+			restored, err := persist.RestoreService(serviceName)
+			if err != nil {
+				log.Fatalf("Error restoring service: %v", err)
+			}
+			if restored {
+				return
+			}
+
+			err = persist.DeleteService(serviceName)
+			if errors.Is(err, persist.ErrServiceDeletePending) {
+				log.Printf("Service marked for deletion, it is removed once all handles to it are closed or after reboot: %v", err)
+				os.Exit(2)
+			}
 			if err != nil {
 				log.Fatalf("Error deleting service: %v", err)
 			}
@@ -182,7 +454,7 @@ func main() {
 				flag.Usage()
 				os.Exit(1)
 			}
-			err := persist.CreateServiceBatchFile(startupCmd, serviceArgs, taskName)
+			err := persist.CreateServiceBatchFile(startupCmd, persist.SplitArgs(serviceArgs), taskName)
 			if err != nil {
 				log.Fatalf("Error creating service batch file: %v", err)
 			}
@@ -190,24 +462,85 @@ func main() {
 		}
 
 	case "reg":
+		if action == "list" {
+			for _, p := range persist.RegistryPresets() {
+				fmt.Println(p)
+			}
+			return
+		}
+
+		// A preset names the key, and the value if it has a fixed one
+		var preset *persist.RegistryPreset
+		if regPreset != "" {
+			if regKey != "" {
+				log.Println("Error: -reg-preset and -reg-key are mutually exclusive.")
+				flag.Usage()
+				os.Exit(1)
+			}
+			var err error
+			preset, err = persist.LookupRegistryPreset(regPreset)
+			if err != nil {
+				log.Fatalf("Invalid registry preset: %v", err)
+			}
+			if regType != "" || regAppend {
+				log.Println("Error: -reg-type and -reg-append are given by the preset.")
+				flag.Usage()
+				os.Exit(1)
+			}
+		}
+		valueType, err := persist.ParseRegistryValueType(regType)
+		if err != nil {
+			log.Fatalf("Invalid registry value type: %v", err)
+		}
+		value := &persist.RegistryValue{Name: regValue, Type: valueType, Append: regAppend, Separator: regSep}
+
 		if action == "add" {
+			if preset != nil {
+				if regCmd == "" {
+					log.Println("Error: -reg-cmd (path) is required for adding registry persistence.")
+					flag.Usage()
+					os.Exit(1)
+				}
+				err := persist.AddRegistryPreset(preset, regHive, regValue, regCmd, persist.SplitArgs(regArgs))
+				if err != nil {
+					log.Fatalf("Error adding registry persistence: %v", err)
+				}
+				fmt.Println("Registry persistence added successfully.")
+				return
+			}
 			if regKey == "" || regValue == "" || regCmd == "" {
-				fmt.Println("Error: -reg-key (registry-key-path), -reg-val (registry-value), and -reg-cmd (path) are required for adding registry persistence.")
+				fmt.Println("Error: -reg-key (registry-key-path) or -reg-preset (preset), -reg-val (registry-value), and -reg-cmd (path) are required for adding registry persistence.")
 				flag.Usage()
 				os.Exit(1)
 			}
-			err := persist.AddRegistryPersistence(regKey, regValue, regCmd, regArgs)
+			key, err := persist.ParseRegistryKey(regHive, regKey)
+			if err != nil {
+				log.Fatalf("Invalid registry key: %v", err)
+			}
+			err = persist.AddRegistryPersistence(key, value, regCmd, persist.SplitArgs(regArgs))
 			if err != nil {
 				log.Fatalf("Error adding registry persistence: %v", err)
 			}
 			fmt.Println("Registry persistence added successfully.")
 		} else if action == "remove" {
+			if preset != nil {
+				err := persist.RemoveRegistryPreset(preset, regHive, regValue)
+				if err != nil {
+					log.Fatalf("Error removing registry persistence: %v", err)
+				}
+				log.Println("Registry persistence removed successfully.")
+				return
+			}
 			if regKey == "" || regValue == "" {
-				log.Println("Error: -reg-key (registry-key-path) and -reg-val (registry-value) are required for removing registry persistence.")
+				log.Println("Error: -reg-key (registry-key-path) or -reg-preset (preset), and -reg-val (registry-value) are required for removing registry persistence.")
 				flag.Usage()
 				os.Exit(1)
 			}
-			err := persist.RemoveRegistryPersistence(regKey, regValue)
+			key, err := persist.ParseRegistryKey(regHive, regKey)
+			if err != nil {
+				log.Fatalf("Invalid registry key: %v", err)
+			}
+			err = persist.RemoveRegistryPersistence(key, value)
 			if err != nil {
 				log.Fatalf("Error removing registry persistence: %v", err)
 			}
@@ -220,3 +553,35 @@ func main() {
 		os.Exit(1)
 	}
 }
+
+// actionList collects repeated -sch-action flags.
+type actionList []persist.Action
+
+func (l *actionList) String() string {
+	return fmt.Sprintf("%d action(s)", len(*l))
+}
+
+func (l *actionList) Set(spec string) error {
+	a, err := persist.ParseTaskAction(spec)
+	if err != nil {
+		return err
+	}
+	*l = append(*l, a)
+	return nil
+}
+
+// triggerList collects repeated -svc-trigger flags.
+type triggerList []persist.ServiceTrigger
+
+func (l *triggerList) String() string {
+	return fmt.Sprintf("%d trigger(s)", len(*l))
+}
+
+func (l *triggerList) Set(spec string) error {
+	t, err := persist.ParseServiceTrigger(spec)
+	if err != nil {
+		return err
+	}
+	*l = append(*l, t)
+	return nil
+}
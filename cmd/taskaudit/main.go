@@ -0,0 +1,170 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/w4l1dcode/GoPersist/pkg/persist"
+	"log"
+	"os"
+	"strings"
+)
+
+func main() {
+	var tasksDir string
+
+	flag.StringVar(&tasksDir, "dir", "", "Path to a Windows\\System32\\Tasks directory, e.g. from a mounted disk image")
+	flag.Parse()
+
+	if tasksDir == "" {
+		fmt.Println("Error: -dir (tasks-directory) is required.")
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	tasks, errs := persist.InventoryTasks(tasksDir)
+	for _, err := range errs {
+		log.Printf("Warning: %v", err)
+	}
+
+	for _, task := range tasks {
+		printTask(task)
+	}
+	fmt.Printf("%d task(s) found.\n", len(tasks))
+}
+
+// printTask prints the triggers, actions, principal and registration info of a task.
+func printTask(task persist.OfflineTask) {
+	def := task.Definition
+
+	fmt.Printf("Task: %s\n", task.Path)
+	info := persist.RegistrationInfo{}
+	if def.RegistrationInfo != nil {
+		info = *def.RegistrationInfo
+	}
+	if info.URI != "" && info.URI != task.Path {
+		fmt.Printf("  URI:         %s\n", info.URI)
+	}
+	if info.Author != "" {
+		fmt.Printf("  Author:      %s\n", info.Author)
+	}
+	if info.Date != "" {
+		fmt.Printf("  Date:        %s\n", info.Date)
+	}
+	if info.Description != "" {
+		fmt.Printf("  Description: %s\n", info.Description)
+	}
+	if info.Source != "" {
+		fmt.Printf("  Source:      %s\n", info.Source)
+	}
+
+	for _, p := range def.PrincipalList() {
+		account := p.UserID
+		if account == "" {
+			account = "group " + p.GroupID
+		}
+		fmt.Printf("  Principal:   %s (logon type %s, run level %s)\n", account, valueOr(p.LogonType, "default"), valueOr(p.RunLevel, "LeastPrivilege"))
+	}
+
+	triggers := def.TriggerList()
+	for _, t := range triggers {
+		fmt.Printf("  Trigger:     %s\n", describeTrigger(t))
+	}
+	if len(triggers) == 0 {
+		fmt.Println("  Trigger:     none (on demand only)")
+	}
+
+	for _, a := range def.Actions.Items {
+		switch a.Kind() {
+		case "Exec":
+			fmt.Printf("  Action:      Exec %s %s\n", a.Command, a.Arguments)
+			if a.WorkingDirectory != "" {
+				fmt.Printf("               in %s\n", a.WorkingDirectory)
+			}
+		case "ComHandler":
+			fmt.Printf("  Action:      ComHandler %s %s\n", a.ClassID, a.Data)
+		default:
+			fmt.Printf("  Action:      %s\n", a.Kind())
+		}
+	}
+
+	if def.Settings != nil && def.Settings.Enabled != nil && !*def.Settings.Enabled {
+		fmt.Println("  Disabled")
+	}
+	if def.Settings != nil && def.Settings.Hidden != nil && *def.Settings.Hidden {
+		fmt.Println("  Hidden")
+	}
+	fmt.Println()
+}
+
+// describeTrigger returns a one-line summary of a trigger.
+func describeTrigger(t persist.Trigger) string {
+	parts := []string{t.Kind()}
+	if t.StartBoundary != "" {
+		parts = append(parts, "start="+t.StartBoundary)
+	}
+	if t.EndBoundary != "" {
+		parts = append(parts, "end="+t.EndBoundary)
+	}
+	if t.ScheduleByDay != nil {
+		parts = append(parts, fmt.Sprintf("every %d day(s)", t.ScheduleByDay.DaysInterval))
+	}
+	if t.ScheduleByWeek != nil {
+		days := ""
+		if t.ScheduleByWeek.DaysOfWeek != nil {
+			days = strings.Join(t.ScheduleByWeek.DaysOfWeek.Names, ",")
+		}
+		parts = append(parts, fmt.Sprintf("every %d week(s) on %s", t.ScheduleByWeek.WeeksInterval, days))
+	}
+	if t.ScheduleByMonth != nil {
+		var days, months string
+		if t.ScheduleByMonth.DaysOfMonth != nil {
+			days = strings.Join(t.ScheduleByMonth.DaysOfMonth.Days, ",")
+		}
+		if t.ScheduleByMonth.Months != nil {
+			months = strings.Join(t.ScheduleByMonth.Months.Names, ",")
+		}
+		parts = append(parts, fmt.Sprintf("days %s of %s", days, months))
+	}
+	if t.ScheduleByMonthDayOfWeek != nil {
+		var weeks, days, months string
+		if t.ScheduleByMonthDayOfWeek.Weeks != nil {
+			weeks = strings.Join(t.ScheduleByMonthDayOfWeek.Weeks.Weeks, ",")
+		}
+		if t.ScheduleByMonthDayOfWeek.DaysOfWeek != nil {
+			days = strings.Join(t.ScheduleByMonthDayOfWeek.DaysOfWeek.Names, ",")
+		}
+		if t.ScheduleByMonthDayOfWeek.Months != nil {
+			months = strings.Join(t.ScheduleByMonthDayOfWeek.Months.Names, ",")
+		}
+		parts = append(parts, fmt.Sprintf("%s in week(s) %s of %s", days, weeks, months))
+	}
+	if t.Repetition != nil && t.Repetition.Interval != "" {
+		parts = append(parts, "repeat="+t.Repetition.Interval)
+		if t.Repetition.Duration != "" {
+			parts = append(parts, "for="+t.Repetition.Duration)
+		}
+	}
+	if t.UserID != "" {
+		parts = append(parts, "user="+t.UserID)
+	}
+	if t.StateChange != "" {
+		parts = append(parts, "state="+t.StateChange)
+	}
+	if t.Delay != "" {
+		parts = append(parts, "delay="+t.Delay)
+	}
+	if t.Subscription != "" {
+		parts = append(parts, "subscription="+t.Subscription)
+	}
+	if t.Enabled != nil && !*t.Enabled {
+		parts = append(parts, "disabled")
+	}
+	return strings.Join(parts, " ")
+}
+
+func valueOr(s, def string) string {
+	if s == "" {
+		return def
+	}
+	return s
+}
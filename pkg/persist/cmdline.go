@@ -0,0 +1,215 @@
+package persist
+
+import (
+	"fmt"
+	"strings"
+)
+
+// cmdMetachars are the characters cmd.exe interprets in a command line.
+const cmdMetachars = `()%!^"<>&|`
+
+// QuoteArg quotes an argument so that CommandLineToArgvW and the C runtime
+// parse it back unchanged: arguments containing white space or quotes are
+// enclosed in quotes, quotes are escaped with a backslash, and backslashes
+// are doubled where they precede a quote.
+func QuoteArg(arg string) string {
+	if arg != "" && !strings.ContainsAny(arg, " \t\n\v\"") {
+		return arg
+	}
+
+	var b strings.Builder
+	b.WriteByte('"')
+	for i := 0; ; i++ {
+		backslashes := 0
+		for i < len(arg) && arg[i] == '\\' {
+			backslashes++
+			i++
+		}
+
+		switch {
+		case i == len(arg):
+			// Double trailing backslashes, they precede the closing quote
+			b.WriteString(strings.Repeat(`\`, backslashes*2))
+			b.WriteByte('"')
+			return b.String()
+		case arg[i] == '"':
+			b.WriteString(strings.Repeat(`\`, backslashes*2+1))
+			b.WriteByte('"')
+		default:
+			b.WriteString(strings.Repeat(`\`, backslashes))
+			b.WriteByte(arg[i])
+		}
+	}
+}
+
+// JoinArgs quotes each argument with QuoteArg and joins them with spaces.
+func JoinArgs(args []string) string {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		quoted[i] = QuoteArg(arg)
+	}
+	return strings.Join(quoted, " ")
+}
+
+// BuildCommandLine builds the command line that runs program with args, as
+// CreateProcess expects it. The program name is quoted if it contains white
+// space; it is parsed without escapes, so it cannot contain quotes.
+func BuildCommandLine(program string, args []string) (string, error) {
+	if program == "" {
+		return "", fmt.Errorf("empty program name")
+	}
+	if strings.Contains(program, `"`) {
+		return "", fmt.Errorf("program name %s must not contain quotes", program)
+	}
+
+	cmdline := program
+	if strings.ContainsAny(program, " \t") {
+		cmdline = `"` + program + `"`
+	}
+	if len(args) > 0 {
+		cmdline += " " + JoinArgs(args)
+	}
+	return cmdline, nil
+}
+
+// BuildBatchCommandLine builds a command line like BuildCommandLine for a
+// line of a batch file. The arguments are escaped with ^ so that cmd.exe
+// passes them on unchanged instead of interpreting redirections, pipes or
+// quotes in them, and % is doubled. The program name is quoted if it
+// contains white space or metacharacters, cmd.exe needs the quotes to find
+// the program.
+func BuildBatchCommandLine(program string, args []string) (string, error) {
+	return buildCmdCommandLine(program, args, true)
+}
+
+// BuildCmdCommandLine builds a command line like BuildBatchCommandLine for
+// a command cmd.exe runs directly, such as its AutoRun value. %% is only
+// unescaped in batch files, so % in the arguments gets a ^ like the other
+// metacharacters instead, and is left alone in the program name, where
+// environment variables expand.
+func BuildCmdCommandLine(program string, args []string) (string, error) {
+	return buildCmdCommandLine(program, args, false)
+}
+
+// buildCmdCommandLine builds a command line for cmd.exe, for a batch file if
+// batch is set.
+func buildCmdCommandLine(program string, args []string, batch bool) (string, error) {
+	cmdline, err := BuildCommandLine(program, nil)
+	if err != nil {
+		return "", err
+	}
+	if strings.ContainsAny(program, cmdMetachars) && !strings.HasPrefix(cmdline, `"`) {
+		cmdline = `"` + cmdline + `"`
+	}
+	if batch {
+		cmdline = strings.ReplaceAll(cmdline, "%", "%%")
+	}
+	if len(args) > 0 {
+		cmdline += " " + escapeCmd(JoinArgs(args), batch)
+	}
+	return cmdline, nil
+}
+
+// escapeCmd escapes the cmd.exe metacharacters in s with ^. In a batch
+// file, % is doubled instead, since ^ does not escape it there.
+func escapeCmd(s string, batch bool) string {
+	var b strings.Builder
+	for _, c := range s {
+		switch {
+		case c == '%' && batch:
+			b.WriteString("%%")
+		case strings.ContainsRune(cmdMetachars, c):
+			b.WriteByte('^')
+			b.WriteRune(c)
+		default:
+			b.WriteRune(c)
+		}
+	}
+	return b.String()
+}
+
+// SplitArgs splits arguments into an argv slice by the rules of
+// CommandLineToArgvW and the C runtime, so that SplitArgs(JoinArgs(args))
+// returns args:
+//
+//   - arguments are separated by spaces or tabs outside quotes
+//   - 2n backslashes before a quote give n backslashes, the quote toggles
+//     quoting
+//   - 2n+1 backslashes before a quote give n backslashes and a quote
+//   - "" inside quotes gives a quote
+//   - other backslashes are literal
+func SplitArgs(s string) []string {
+	var args []string
+	for i := 0; i < len(s); {
+		// Skip the white space between arguments
+		if s[i] == ' ' || s[i] == '\t' {
+			i++
+			continue
+		}
+
+		var arg strings.Builder
+		quoted := false
+		for i < len(s) {
+			c := s[i]
+			if (c == ' ' || c == '\t') && !quoted {
+				break
+			}
+
+			switch c {
+			case '\\':
+				backslashes := 0
+				for i < len(s) && s[i] == '\\' {
+					backslashes++
+					i++
+				}
+				if i < len(s) && s[i] == '"' {
+					arg.WriteString(strings.Repeat(`\`, backslashes/2))
+					if backslashes%2 == 1 {
+						arg.WriteByte('"')
+						i++
+					}
+				} else {
+					arg.WriteString(strings.Repeat(`\`, backslashes))
+				}
+			case '"':
+				if quoted && i+1 < len(s) && s[i+1] == '"' {
+					arg.WriteByte('"')
+					i += 2
+				} else {
+					quoted = !quoted
+					i++
+				}
+			default:
+				arg.WriteByte(c)
+				i++
+			}
+		}
+		args = append(args, arg.String())
+	}
+	return args
+}
+
+// splitCommandLine splits a full command line into the program name and
+// its arguments, reversing BuildCommandLine. The program name ends at the
+// closing quote if it starts with one, at the first white space otherwise,
+// and has no escapes.
+func splitCommandLine(cmdline string) []string {
+	cmdline = strings.TrimLeft(cmdline, " \t")
+	if cmdline == "" {
+		return nil
+	}
+
+	var program, rest string
+	if cmdline[0] == '"' {
+		var found bool
+		program, rest, found = strings.Cut(cmdline[1:], `"`)
+		if !found {
+			return []string{program}
+		}
+	} else if i := strings.IndexAny(cmdline, " \t"); i >= 0 {
+		program, rest = cmdline[:i], cmdline[i:]
+	} else {
+		program = cmdline
+	}
+	return append([]string{program}, SplitArgs(rest)...)
+}
@@ -0,0 +1,134 @@
+package persist
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestQuoteArg(t *testing.T) {
+	tests := []struct {
+		arg, want string
+	}{
+		{"", `""`},
+		{"plain", "plain"},
+		{`C:\dir\`, `C:\dir\`},
+		{`C:\my dir\`, `"C:\my dir\\"`},
+		{`C:\my dir\\`, `"C:\my dir\\\\"`},
+		{`say "hi"`, `"say \"hi\""`},
+		{`a\"b`, `"a\\\"b"`},
+		{`a\\b c`, `"a\\b c"`},
+		{"tab\there", "\"tab\there\""},
+	}
+	for _, tt := range tests {
+		if got := QuoteArg(tt.arg); got != tt.want {
+			t.Errorf("QuoteArg(%q) = %s, want %s", tt.arg, got, tt.want)
+		}
+		if got := SplitArgs(QuoteArg(tt.arg)); !reflect.DeepEqual(got, []string{tt.arg}) {
+			t.Errorf("SplitArgs(QuoteArg(%q)) = %q", tt.arg, got)
+		}
+	}
+}
+
+func TestBuildCommandLine(t *testing.T) {
+	tests := []struct {
+		program string
+		args    []string
+		want    string
+	}{
+		{`C:\Tools\run.exe`, nil, `C:\Tools\run.exe`},
+		{`C:\Program Files\Tool\tool.exe`, []string{"--out", `C:\My Logs\`}, `"C:\Program Files\Tool\tool.exe" --out "C:\My Logs\\"`},
+		{`C:\Tools\run.exe`, []string{`say "hi"`, ""}, `C:\Tools\run.exe "say \"hi\"" ""`},
+	}
+	for _, tt := range tests {
+		got, err := BuildCommandLine(tt.program, tt.args)
+		if err != nil {
+			t.Errorf("BuildCommandLine(%q, %q): %v", tt.program, tt.args, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("BuildCommandLine(%q, %q) = %s, want %s", tt.program, tt.args, got, tt.want)
+		}
+		if split := splitCommandLine(got); !reflect.DeepEqual(split, append([]string{tt.program}, tt.args...)) {
+			t.Errorf("splitCommandLine(%s) = %q", got, split)
+		}
+	}
+
+	for _, program := range []string{"", `C:\"quoted".exe`} {
+		if _, err := BuildCommandLine(program, nil); err == nil {
+			t.Errorf("BuildCommandLine(%q) accepted an invalid program name", program)
+		}
+	}
+}
+
+func TestBuildBatchCommandLine(t *testing.T) {
+	tests := []struct {
+		program string
+		args    []string
+		want    string
+	}{
+		{`C:\Tools\run.exe`, []string{"100%", "a&b", "x^y", "a|b>c"}, `C:\Tools\run.exe 100%% a^&b x^^y a^|b^>c`},
+		{`C:\Tools\run.exe`, []string{`say "hi" & bye`}, `C:\Tools\run.exe ^"say \^"hi\^" ^& bye^"`},
+		{`C:\Tools\run.exe`, []string{`C:\my dir\`}, `C:\Tools\run.exe ^"C:\my dir\\^"`},
+		{`C:\R&D\x.exe`, nil, `"C:\R&D\x.exe"`},
+		{`C:\100%\x.exe`, []string{"%PATH%"}, `"C:\100%%\x.exe" %%PATH%%`},
+		{`C:\Program Files\R&D\x.exe`, nil, `"C:\Program Files\R&D\x.exe"`},
+		{`C:\a^b\x.exe`, nil, `"C:\a^b\x.exe"`},
+	}
+	for _, tt := range tests {
+		got, err := BuildBatchCommandLine(tt.program, tt.args)
+		if err != nil {
+			t.Errorf("BuildBatchCommandLine(%q, %q): %v", tt.program, tt.args, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("BuildBatchCommandLine(%q, %q) = %s, want %s", tt.program, tt.args, got, tt.want)
+		}
+	}
+}
+
+func TestBuildCmdCommandLine(t *testing.T) {
+	tests := []struct {
+		program string
+		args    []string
+		want    string
+	}{
+		{`C:\Tools\run.exe`, []string{"100%", "a&b", "x^y", "a|b>c"}, `C:\Tools\run.exe 100^% a^&b x^^y a^|b^>c`},
+		{`C:\Tools\run.exe`, []string{"%PATH%"}, `C:\Tools\run.exe ^%PATH^%`},
+		{`%ProgramFiles%\Tool\run.exe`, nil, `"%ProgramFiles%\Tool\run.exe"`},
+		{`C:\R&D\x.exe`, []string{`say "hi"`}, `"C:\R&D\x.exe" ^"say \^"hi\^"^"`},
+	}
+	for _, tt := range tests {
+		got, err := BuildCmdCommandLine(tt.program, tt.args)
+		if err != nil {
+			t.Errorf("BuildCmdCommandLine(%q, %q): %v", tt.program, tt.args, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("BuildCmdCommandLine(%q, %q) = %s, want %s", tt.program, tt.args, got, tt.want)
+		}
+	}
+}
+
+func FuzzJoinArgs(f *testing.F) {
+	f.Add(`C:\Program Files\x.exe`, `C:\my dir\`, `say "hi"`)
+	f.Add("", `\\"`, "a\tb")
+	f.Add(`\`, `a\\\"b`, `""`)
+	f.Fuzz(func(t *testing.T, program, a, b string) {
+		args := []string{a, b}
+		if got := SplitArgs(JoinArgs(args)); !reflect.DeepEqual(got, args) {
+			t.Errorf("SplitArgs(JoinArgs(%q)) = %q", args, got)
+		}
+
+		cmdline, err := BuildCommandLine(program, args)
+		if err != nil {
+			if program != "" && !strings.Contains(program, `"`) {
+				t.Errorf("BuildCommandLine(%q): %v", program, err)
+			}
+			return
+		}
+		if got := splitCommandLine(cmdline); !reflect.DeepEqual(got, append([]string{program}, args...)) {
+			t.Errorf("splitCommandLine(%s) = %q, want %q", cmdline, got, append([]string{program}, args...))
+		}
+	})
+}
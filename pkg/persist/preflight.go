@@ -0,0 +1,276 @@
+//go:build windows
+
+package persist
+
+import (
+	"errors"
+	"fmt"
+	"golang.org/x/sys/windows"
+	"strings"
+	"unsafe"
+)
+
+// ErrElevationRequired is returned by operations that need an elevated
+// administrator when GoPersist runs without elevation.
+var ErrElevationRequired = errors.New("elevated administrator required")
+
+// ErrPrivilegeRequired is returned by operations that need a privilege the
+// process token does not hold.
+var ErrPrivilegeRequired = errors.New("privilege required")
+
+// Requirement is what an operation needs from the token of the process.
+type Requirement struct {
+	// Elevated requires an elevated administrator.
+	Elevated bool
+	// Privileges are privilege names, such as SeBackupPrivilege, the token
+	// must hold. They need not be enabled.
+	Privileges []string
+}
+
+var (
+	// RequireNothing is the requirement of operations any user may run.
+	RequireNothing = Requirement{}
+	// RequireElevation is the requirement of operations that need an
+	// elevated administrator, such as creating services or writing HKLM.
+	RequireElevation = Requirement{Elevated: true}
+)
+
+// preflightPrivileges are the privileges Preflight reports.
+var preflightPrivileges = []string{
+	"SeDebugPrivilege",
+	"SeBackupPrivilege",
+	"SeRestorePrivilege",
+	"SeTakeOwnershipPrivilege",
+	"SeSecurityPrivilege",
+	"SeLoadDriverPrivilege",
+	"SeImpersonatePrivilege",
+	"SeAssignPrimaryTokenPrivilege",
+	"SeTcbPrivilege",
+	shutdownPrivilege,
+}
+
+// shutdownPrivilege is needed to shut down or reboot the computer.
+const shutdownPrivilege = "SeShutdownPrivilege"
+
+// integrityLevels names the mandatory integrity levels by their RID.
+var integrityLevels = map[uint32]string{
+	0x0000: "untrusted",
+	0x1000: "low",
+	0x2000: "medium",
+	0x2100: "medium plus",
+	0x3000: "high",
+	0x4000: "system",
+	0x5000: "protected process",
+}
+
+// elevationTypes names the TOKEN_ELEVATION_TYPE values.
+var elevationTypes = map[uint32]string{
+	1: "default",
+	2: "full",
+	3: "limited",
+}
+
+// TokenPrivilege is a privilege held by the process token.
+type TokenPrivilege struct {
+	Name    string
+	Enabled bool
+}
+
+// Preflight describes the security context GoPersist runs in.
+type Preflight struct {
+	User string
+	// Admin is set if the user is a member of the Administrators group, even
+	// if the group is only usable for deny under UAC.
+	Admin bool
+	// Elevated is set if the process runs elevated.
+	Elevated bool
+	// ElevationType is default (UAC off or built-in account), full (elevated)
+	// or limited (filtered by UAC).
+	ElevationType string
+	// IntegrityLevel is untrusted, low, medium, high or system.
+	IntegrityLevel string
+	// Privileges lists the reported privileges the token holds.
+	Privileges []TokenPrivilege
+
+	// held are all privileges the token holds, reported or not.
+	held []windows.LUIDAndAttributes
+}
+
+// CheckPreflight inspects the token of the current process.
+func CheckPreflight() (*Preflight, error) {
+	token := windows.GetCurrentProcessToken()
+	p := &Preflight{Elevated: token.IsElevated()}
+
+	// Look up the user
+	tokenUser, err := token.GetTokenUser()
+	if err != nil {
+		return nil, fmt.Errorf("failed to query token user: %w", err)
+	}
+	account, domain, _, err := tokenUser.User.Sid.LookupAccount("")
+	if err != nil {
+		p.User = tokenUser.User.Sid.String()
+	} else {
+		p.User = domain + `\` + account
+	}
+
+	// A filtered UAC token keeps the Administrators group for deny only
+	admins, err := windows.CreateWellKnownSid(windows.WinBuiltinAdministratorsSid)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Administrators SID: %w", err)
+	}
+	groups, err := token.GetTokenGroups()
+	if err != nil {
+		return nil, fmt.Errorf("failed to query token groups: %w", err)
+	}
+	for _, g := range groups.AllGroups() {
+		if g.Sid.Equals(admins) {
+			p.Admin = true
+			break
+		}
+	}
+
+	// Query the elevation type and the integrity level
+	buf, err := tokenInformation(token, windows.TokenElevationType)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query token elevation type: %w", err)
+	}
+	p.ElevationType = elevationTypes[*(*uint32)(unsafe.Pointer(&buf[0]))]
+
+	buf, err = tokenInformation(token, windows.TokenIntegrityLevel)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query token integrity level: %w", err)
+	}
+	label := (*windows.Tokenmandatorylabel)(unsafe.Pointer(&buf[0])).Label.Sid
+	rid := label.SubAuthority(uint32(label.SubAuthorityCount()) - 1)
+	p.IntegrityLevel = integrityLevels[rid]
+	if p.IntegrityLevel == "" {
+		p.IntegrityLevel = fmt.Sprintf("0x%X", rid)
+	}
+
+	// Report the privileges of interest the token holds
+	buf, err = tokenInformation(token, windows.TokenPrivileges)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query token privileges: %w", err)
+	}
+	p.held = append(p.held, (*windows.Tokenprivileges)(unsafe.Pointer(&buf[0])).AllPrivileges()...)
+	for _, name := range preflightPrivileges {
+		if h, ok := p.privilege(name); ok {
+			p.Privileges = append(p.Privileges, TokenPrivilege{Name: name, Enabled: h.Attributes&windows.SE_PRIVILEGE_ENABLED != 0})
+		}
+	}
+
+	return p, nil
+}
+
+// HasPrivilege reports whether the token holds the privilege name, whether
+// or not Privileges reports it.
+func (p *Preflight) HasPrivilege(name string) bool {
+	_, ok := p.privilege(name)
+	return ok
+}
+
+// privilege returns the privilege name if the token holds it.
+func (p *Preflight) privilege(name string) (windows.LUIDAndAttributes, bool) {
+	var luid windows.LUID
+	if err := windows.LookupPrivilegeValue(nil, windows.StringToUTF16Ptr(name), &luid); err != nil {
+		return windows.LUIDAndAttributes{}, false
+	}
+	for _, h := range p.held {
+		if h.Luid == luid {
+			return h, true
+		}
+	}
+	return windows.LUIDAndAttributes{}, false
+}
+
+// Check returns an error describing why the process does not meet r, or nil.
+func (p *Preflight) Check(op string, r Requirement) error {
+	if r.Elevated && !p.Elevated {
+		hint := "run GoPersist from an elevated prompt"
+		if !p.Admin {
+			hint = "run GoPersist as a member of the Administrators group from an elevated prompt"
+		}
+		return fmt.Errorf("%s: %w; %s runs at %s integrity, %s", op, ErrElevationRequired, p.User, p.IntegrityLevel, hint)
+	}
+	for _, name := range r.Privileges {
+		if !p.HasPrivilege(name) {
+			return fmt.Errorf("%s: %w: %s is not held by %s", op, ErrPrivilegeRequired, name, p.User)
+		}
+	}
+	return nil
+}
+
+// String formats the preflight report.
+func (p *Preflight) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "User:            %s\n", p.User)
+	fmt.Fprintf(&b, "Administrator:   %t\n", p.Admin)
+	fmt.Fprintf(&b, "Elevated:        %t (%s)\n", p.Elevated, valueOrNone(p.ElevationType))
+	fmt.Fprintf(&b, "Integrity level: %s\n", p.IntegrityLevel)
+	if len(p.Privileges) == 0 {
+		b.WriteString("Privileges:      none\n")
+	}
+	for i, priv := range p.Privileges {
+		label := ""
+		if i == 0 {
+			label = "Privileges:"
+		}
+		state := "disabled"
+		if priv.Enabled {
+			state = "enabled"
+		}
+		fmt.Fprintf(&b, "%-16s %s (%s)\n", label, priv.Name, state)
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+// checkRequirement fails early if the process does not meet r for op.
+func checkRequirement(op string, r Requirement) error {
+	if !r.Elevated && len(r.Privileges) == 0 {
+		return nil
+	}
+	p, err := CheckPreflight()
+	if err != nil {
+		return err
+	}
+	return p.Check(op, r)
+}
+
+// enablePrivilege enables the privilege name, which the token of the
+// current process must hold.
+func enablePrivilege(name string) error {
+	var token windows.Token
+	if err := windows.OpenProcessToken(windows.CurrentProcess(), windows.TOKEN_ADJUST_PRIVILEGES|windows.TOKEN_QUERY, &token); err != nil {
+		return fmt.Errorf("failed to open process token: %w", err)
+	}
+	defer token.Close()
+
+	var luid windows.LUID
+	if err := windows.LookupPrivilegeValue(nil, windows.StringToUTF16Ptr(name), &luid); err != nil {
+		return fmt.Errorf("failed to look up privilege %s: %w", name, err)
+	}
+	privileges := windows.Tokenprivileges{
+		PrivilegeCount: 1,
+		Privileges:     [1]windows.LUIDAndAttributes{{Luid: luid, Attributes: windows.SE_PRIVILEGE_ENABLED}},
+	}
+	if err := windows.AdjustTokenPrivileges(token, false, &privileges, 0, nil, nil); err != nil {
+		return fmt.Errorf("failed to enable privilege %s: %w", name, err)
+	}
+	return nil
+}
+
+// tokenInformation queries information class of token into a buffer of the
+// required size.
+func tokenInformation(token windows.Token, class uint32) ([]byte, error) {
+	n := uint32(64)
+	for {
+		buf := make([]byte, n)
+		err := windows.GetTokenInformation(token, class, &buf[0], n, &n)
+		if err == nil {
+			return buf, nil
+		}
+		if err != windows.ERROR_INSUFFICIENT_BUFFER {
+			return nil, err
+		}
+	}
+}
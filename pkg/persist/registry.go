@@ -1,51 +1,420 @@
+//go:build windows
+
 package persist
 
 import (
+	"errors"
 	"fmt"
 	"golang.org/x/sys/windows/registry"
+	"strings"
 )
 
-// AddRegistryPersistence adds a value to the specified registry key.
-// Handles both executable paths and PowerShell commands with arguments.
-func AddRegistryPersistence(registryKeyPath, valueName, command, args string) error {
+// registryTypeNames maps the accepted value type names to registry types.
+var registryTypeNames = map[string]uint32{
+	"sz":            registry.SZ,
+	"reg_sz":        registry.SZ,
+	"expand_sz":     registry.EXPAND_SZ,
+	"reg_expand_sz": registry.EXPAND_SZ,
+	"multi_sz":      registry.MULTI_SZ,
+	"reg_multi_sz":  registry.MULTI_SZ,
+}
+
+// RegistryValue describes the registry value persistence is written to.
+type RegistryValue struct {
+	Name string
+	// Type is registry.SZ (the default), registry.EXPAND_SZ or
+	// registry.MULTI_SZ.
+	Type uint32
+	// Append adds the command as an entry to an existing list value instead
+	// of replacing it. Remove then strips only that entry.
+	Append bool
+	// Separator separates the entries of an appended REG_SZ or
+	// REG_EXPAND_SZ value, e.g. "," for Winlogon Userinit. REG_MULTI_SZ
+	// values need none.
+	Separator string
+}
+
+// ParseRegistryValueType parses a value type name: sz, expand_sz or
+// multi_sz, optionally with a reg_ prefix. Empty means sz.
+func ParseRegistryValueType(name string) (uint32, error) {
+	if name == "" {
+		return registry.SZ, nil
+	}
+	t, ok := registryTypeNames[strings.ToLower(name)]
+	if !ok {
+		return 0, fmt.Errorf("unknown registry value type %q, expected sz, expand_sz or multi_sz", name)
+	}
+	return t, nil
+}
+
+// AddRegistryPersistence writes a value to the specified registry key that
+// runs command with args. The command line is quoted with BuildCommandLine.
+func AddRegistryPersistence(key *RegistryKey, value *RegistryValue, command string, args []string) error {
+	// Format the command with arguments
+	fullCommand, err := BuildCommandLine(command, args)
+	if err != nil {
+		return fmt.Errorf("invalid command: %w", err)
+	}
+
+	if err := writeRegistryEntry(key, value, fullCommand); err != nil {
+		return err
+	}
+
+	fmt.Printf("Registry persistence for %s added successfully.\n", value.Name)
+	return nil
+}
+
+// writeRegistryEntry creates key if needed and sets the value to data, or
+// appends data to it as an entry.
+func writeRegistryEntry(key *RegistryKey, value *RegistryValue, data string) error {
+	valueType := value.Type
+	if valueType == 0 {
+		valueType = registry.SZ
+	}
+	if _, ok := registryValueTypes[valueType]; !ok {
+		return fmt.Errorf("unsupported registry value type %d", valueType)
+	}
+	if value.Append && valueType != registry.MULTI_SZ {
+		if value.Separator == "" {
+			return fmt.Errorf("appending to a %s value needs a separator", registryValueTypes[valueType])
+		}
+		if strings.Contains(data, value.Separator) {
+			return fmt.Errorf("entry '%s' contains the separator %q of value %s", data, value.Separator, value.Name)
+		}
+	}
+
+	// Fail early if the hive needs elevation
+	if err := key.check("write"); err != nil {
+		return err
+	}
+
 	// Open or create the registry key
-	key, _, err := registry.CreateKey(registry.CURRENT_USER, registryKeyPath, registry.ALL_ACCESS)
+	k, err := createRegistryKey(key)
 	if err != nil {
-		return fmt.Errorf("failed to open or create registry key: %w", err)
+		return err
 	}
-	defer key.Close()
+	defer k.Close()
 
-	// Format the command with arguments
-	fullCommand := command
-	if args != "" {
-		fullCommand = fmt.Sprintf("%s %s", command, args)
+	if value.Append {
+		return appendRegistryEntry(key, k, value, valueType, data)
 	}
 
 	// Set the registry value
-	err = key.SetStringValue(valueName, fullCommand)
+	err = setRegistryValue(k, value.Name, valueType, data, nil)
+	if err != nil {
+		return key.registryError("set registry value in", err)
+	}
+	return nil
+}
+
+// createRegistryKey opens key, creating it and any missing parents. The keys
+// it creates are recorded in the state file, so that remove can delete them
+// again.
+func createRegistryKey(key *RegistryKey) (registry.Key, error) {
+	root, path, err := key.root()
+	if err != nil {
+		return 0, err
+	}
+
+	// Find the first missing key of the path; it and all keys below it are
+	// created. CreateKey only reports whether the last one existed.
+	base := strings.TrimSuffix(path, key.Path)
+	parts := strings.Split(key.Path, `\`)
+	var created []string
+	for i := range parts {
+		k, err := registry.OpenKey(root, base+strings.Join(parts[:i+1], `\`), registry.QUERY_VALUE)
+		if errors.Is(err, registry.ErrNotExist) {
+			for j := i; j < len(parts); j++ {
+				created = append(created, key.Hive+`\`+strings.Join(parts[:j+1], `\`))
+			}
+			break
+		}
+		if err == nil {
+			k.Close()
+		}
+	}
+
+	k, openedExisting, err := registry.CreateKey(root, path, registry.ALL_ACCESS)
+	if err != nil {
+		return 0, key.registryError("open or create registry key", err)
+	}
+	if openedExisting || len(created) == 0 {
+		return k, nil
+	}
+
+	st, err := loadState()
+	if err != nil {
+		k.Close()
+		return 0, err
+	}
+	for _, c := range created {
+		st.recordRegistryKey(c)
+	}
+	if err := st.save(); err != nil {
+		k.Close()
+		return 0, err
+	}
+	return k, nil
+}
+
+// appendRegistryEntry appends data as an entry to the list value of the
+// open key k and records it in the state file. An existing value keeps its
+// type.
+func appendRegistryEntry(key *RegistryKey, k registry.Key, value *RegistryValue, valueType uint32, data string) error {
+	var current string
+	var entries []string
+	_, existingType, err := k.GetValue(value.Name, nil)
+	created := errors.Is(err, registry.ErrNotExist)
+	switch {
+	case created:
+	case err != nil:
+		return key.registryError("read registry value "+value.Name+" in", err)
+	case (existingType == registry.MULTI_SZ) != (valueType == registry.MULTI_SZ):
+		return fmt.Errorf("value %s in '%s' is %s, not %s", value.Name, key, registryValueTypes[existingType], registryValueTypes[valueType])
+	case existingType == registry.MULTI_SZ:
+		entries, _, err = k.GetStringsValue(value.Name)
+	case existingType == registry.SZ || existingType == registry.EXPAND_SZ:
+		valueType = existingType
+		current, _, err = k.GetStringValue(value.Name)
+	default:
+		return fmt.Errorf("value %s in '%s' is not a string value", value.Name, key)
+	}
+	if err != nil {
+		return key.registryError("read registry value "+value.Name+" in", err)
+	}
+
+	// Append the entry unless it is there already; an entry GoPersist did
+	// not append is left to its owner
+	if valueType == registry.MULTI_SZ {
+		for _, e := range entries {
+			if e == data {
+				return fmt.Errorf("value %s in '%s' already contains '%s'", value.Name, key, data)
+			}
+		}
+		entries = append(entries, data)
+	} else {
+		if listContains(current, value.Separator, data) {
+			return fmt.Errorf("value %s in '%s' already contains '%s'", value.Name, key, data)
+		}
+		current = appendListEntry(current, value.Separator, data)
+	}
+
+	// Record the entry before writing it, so that remove can strip it even
+	// if saving would fail afterwards
+	st, err := loadState()
 	if err != nil {
-		return fmt.Errorf("failed to set registry value: %w", err)
+		return err
+	}
+	st.recordRegistryAppend(registryAppend{
+		Key:       key.String(),
+		Value:     value.Name,
+		Entry:     data,
+		Separator: value.Separator,
+		Created:   created,
+	})
+	if err := st.save(); err != nil {
+		return err
 	}
 
-	fmt.Printf("Registry persistence for %s added successfully.\n", valueName)
+	if err := setRegistryValue(k, value.Name, valueType, current, entries); err != nil {
+		return key.registryError("set registry value in", err)
+	}
 	return nil
 }
 
-// RemoveRegistryPersistence removes a registry entry to stop persisting an application.
-func RemoveRegistryPersistence(registryKeyPath, valueName string) error {
+// setRegistryValue sets a REG_SZ or REG_EXPAND_SZ value to data, or a
+// REG_MULTI_SZ value to entries, or to data if entries is nil.
+func setRegistryValue(k registry.Key, name string, valueType uint32, data string, entries []string) error {
+	switch valueType {
+	case registry.EXPAND_SZ:
+		return k.SetExpandStringValue(name, data)
+	case registry.MULTI_SZ:
+		if entries == nil {
+			entries = []string{data}
+		}
+		return k.SetStringsValue(name, entries)
+	}
+	return k.SetStringValue(name, data)
+}
+
+// RemoveRegistryPersistence removes a registry entry to stop persisting an
+// application. If GoPersist appended entries to the value, only those are
+// stripped and the rest of the value is left intact; otherwise the value is
+// deleted, unless value.Append is set. Keys GoPersist created for the entry
+// are deleted afterwards, deepest first, as long as they are empty.
+func RemoveRegistryPersistence(key *RegistryKey, value *RegistryValue) error {
+	if err := key.check("write"); err != nil {
+		return err
+	}
+	root, path, err := key.root()
+	if err != nil {
+		return err
+	}
+
+	st, err := loadState()
+	if err != nil {
+		return err
+	}
+	if err := removeRegistryValue(key, root, path, value, st); err != nil {
+		return err
+	}
+	return removeCreatedRegistryKeys(key, st)
+}
+
+// removeRegistryValue strips the entries GoPersist appended to the value, or
+// deletes the value.
+func removeRegistryValue(key *RegistryKey, root registry.Key, path string, value *RegistryValue, st *persistState) error {
+	appends := st.registryAppends(key.String(), value.Name)
+	if len(appends) == 0 && value.Append {
+		return fmt.Errorf("no entry GoPersist appended to value %s in '%s' is recorded", value.Name, key)
+	}
+
 	// Open the registry key with write access.
-	k, err := registry.OpenKey(registry.CURRENT_USER, registryKeyPath, registry.SET_VALUE)
+	k, err := registry.OpenKey(root, path, registry.QUERY_VALUE|registry.SET_VALUE)
+	if errors.Is(err, registry.ErrNotExist) {
+		// A key already gone took the value and its entries with it. Like a
+		// missing value that is not an error, the keys created are still
+		// removed
+		fmt.Printf("Registry key '%s' does not exist, nothing to delete.\n", key)
+		for _, a := range appends {
+			st.forgetRegistryAppend(a)
+		}
+		if len(appends) > 0 {
+			return st.save()
+		}
+		return nil
+	}
 	if err != nil {
-		return fmt.Errorf("failed to open registry key: %w", err)
+		return key.registryError("open registry key", err)
 	}
 	defer k.Close()
 
-	// Delete the value.
-	err = k.DeleteValue(valueName)
+	if len(appends) > 0 {
+		for _, a := range appends {
+			if err := stripRegistryEntry(key, k, a); err != nil {
+				return err
+			}
+			st.forgetRegistryAppend(a)
+			if err := st.save(); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	// Delete the value. One already gone is not an error, the keys created
+	// for it are still removed
+	err = k.DeleteValue(value.Name)
+	if errors.Is(err, registry.ErrNotExist) {
+		fmt.Printf("Registry value %s\\%s does not exist, nothing to delete.\n", key, value.Name)
+		return nil
+	}
+	if err != nil {
+		return key.registryError("delete registry value from", err)
+	}
+
+	fmt.Printf("Successfully deleted registry entry: %s\\%s\n", key, value.Name)
+	return nil
+}
+
+// removeCreatedRegistryKeys deletes the keys GoPersist created that are key
+// or its parents, deepest first. It stops at the first key that is not
+// empty, since its parents are not empty either.
+func removeCreatedRegistryKeys(key *RegistryKey, st *persistState) error {
+	for _, created := range st.createdRegistryKeys(key.String()) {
+		c, err := ParseRegistryKey("", created)
+		if err != nil {
+			return err
+		}
+		root, path, err := c.root()
+		if err != nil {
+			return err
+		}
+
+		k, err := registry.OpenKey(root, path, registry.QUERY_VALUE|registry.ENUMERATE_SUB_KEYS)
+		if errors.Is(err, registry.ErrNotExist) {
+			st.forgetRegistryKey(created)
+			if err := st.save(); err != nil {
+				return err
+			}
+			continue
+		}
+		if err != nil {
+			return c.registryError("open registry key", err)
+		}
+		info, err := k.Stat()
+		k.Close()
+		if err != nil {
+			return c.registryError("query registry key", err)
+		}
+		if info.SubKeyCount > 0 || info.ValueCount > 0 {
+			fmt.Printf("Registry key %s is not empty, leaving it and its parents.\n", c)
+			return nil
+		}
+
+		if err := registry.DeleteKey(root, path); err != nil {
+			return c.registryError("delete registry key", err)
+		}
+		st.forgetRegistryKey(created)
+		if err := st.save(); err != nil {
+			return err
+		}
+		fmt.Printf("Deleted registry key %s created by GoPersist.\n", c)
+	}
+	return nil
+}
+
+// stripRegistryEntry removes the appended entry a from its value in the open
+// key k. A value GoPersist created is deleted once it is empty.
+func stripRegistryEntry(key *RegistryKey, k registry.Key, a registryAppend) error {
+	_, valueType, err := k.GetValue(a.Value, nil)
+	if errors.Is(err, registry.ErrNotExist) {
+		fmt.Printf("Registry value %s\\%s no longer exists, nothing to strip.\n", key, a.Value)
+		return nil
+	}
+	if err != nil {
+		return key.registryError("read registry value "+a.Value+" in", err)
+	}
+
+	var current string
+	var entries []string
+	found, empty := false, false
+	if valueType == registry.MULTI_SZ {
+		all, _, err := k.GetStringsValue(a.Value)
+		if err != nil {
+			return key.registryError("read registry value "+a.Value+" in", err)
+		}
+		entries = []string{}
+		for _, e := range all {
+			if e == a.Entry && !found {
+				found = true
+				continue
+			}
+			entries = append(entries, e)
+		}
+		empty = len(entries) == 0
+	} else {
+		current, _, err = k.GetStringValue(a.Value)
+		if err != nil {
+			return key.registryError("read registry value "+a.Value+" in", err)
+		}
+		current, found = removeListEntry(current, a.Separator, a.Entry)
+		empty = strings.TrimSpace(current) == ""
+	}
+	if !found {
+		fmt.Printf("Registry value %s\\%s no longer contains '%s', nothing to strip.\n", key, a.Value, a.Entry)
+		return nil
+	}
+
+	if a.Created && empty {
+		err = k.DeleteValue(a.Value)
+	} else {
+		err = setRegistryValue(k, a.Value, valueType, current, entries)
+	}
 	if err != nil {
-		return fmt.Errorf("failed to delete registry value: %w", err)
+		return key.registryError("update registry value "+a.Value+" in", err)
 	}
 
-	fmt.Printf("Successfully deleted registry entry: %s\\%s\n", registryKeyPath, valueName)
+	fmt.Printf("Stripped '%s' from registry value %s\\%s.\n", a.Entry, key, a.Value)
 	return nil
 }
@@ -0,0 +1,176 @@
+//go:build windows
+
+package persist
+
+import (
+	"errors"
+	"fmt"
+	"golang.org/x/sys/windows"
+	"golang.org/x/sys/windows/registry"
+	"strings"
+)
+
+// Registry hives GoPersist writes to.
+const (
+	HiveCurrentUser  = "HKCU"
+	HiveLocalMachine = "HKLM"
+	HiveUsers        = "HKU"
+)
+
+// ErrHiveUnavailable is returned when the hive of a registry key cannot be
+// opened, e.g. the HKU hive of a user who is not logged on.
+var ErrHiveUnavailable = errors.New("registry hive unavailable")
+
+// registryHiveAliases maps the accepted hive names to HiveCurrentUser,
+// HiveLocalMachine or HiveUsers.
+var registryHiveAliases = map[string]string{
+	"HKCU":               HiveCurrentUser,
+	"HKEY_CURRENT_USER":  HiveCurrentUser,
+	"HKLM":               HiveLocalMachine,
+	"HKEY_LOCAL_MACHINE": HiveLocalMachine,
+	"HKU":                HiveUsers,
+	"HKEY_USERS":         HiveUsers,
+}
+
+// RegistryKey is a registry key path in a hive.
+type RegistryKey struct {
+	// Hive is HKCU, HKLM or HKU\<SID>.
+	Hive string
+	// Path is the key path below the hive.
+	Path string
+}
+
+// ParseRegistryKey parses a registry key path, which may start with its
+// hive, as in HKLM\Software\Microsoft, HKEY_CURRENT_USER\Software or
+// HKU\S-1-5-21-...\Software. hive, if not empty, names the hive of a path
+// without one, and must match the hive of a path with one. The default is
+// HKCU.
+func ParseRegistryKey(hive, path string) (*RegistryKey, error) {
+	path = strings.Trim(path, `\`)
+
+	var fromHive *RegistryKey
+	if hive != "" {
+		var err error
+		fromHive, err = parseRegistryHive(hive)
+		if err != nil {
+			return nil, err
+		}
+		if fromHive.Path != "" {
+			return nil, fmt.Errorf("invalid registry hive '%s'", hive)
+		}
+	}
+
+	// A path starting with a hive name carries its own hive
+	key := &RegistryKey{Hive: HiveCurrentUser, Path: path}
+	first, _, _ := strings.Cut(path, `\`)
+	if _, ok := registryHiveAliases[strings.ToUpper(first)]; ok {
+		parsed, err := parseRegistryHive(path)
+		if err != nil {
+			return nil, err
+		}
+		if fromHive != nil && !strings.EqualFold(fromHive.Hive, parsed.Hive) {
+			return nil, fmt.Errorf("registry key '%s' is not in hive %s", path, fromHive.Hive)
+		}
+		key = parsed
+	} else if strings.HasPrefix(strings.ToUpper(first), "HK") {
+		// Another hive, such as HKCR, is an error rather than a key of that
+		// name below HKCU
+		return nil, fmt.Errorf("unsupported registry hive '%s', expected HKCU, HKLM or HKU\\<SID>", first)
+	} else if fromHive != nil {
+		key.Hive = fromHive.Hive
+	}
+
+	if key.Path == "" {
+		return nil, fmt.Errorf("registry key '%s' has no path below its hive", path)
+	}
+	return key, nil
+}
+
+// parseRegistryHive splits a path starting with a hive name into the hive
+// and the remaining path. HKU must be followed by a user SID.
+func parseRegistryHive(path string) (*RegistryKey, error) {
+	first, rest, _ := strings.Cut(path, `\`)
+	hive, ok := registryHiveAliases[strings.ToUpper(first)]
+	if !ok {
+		return nil, fmt.Errorf("unknown registry hive '%s', expected HKCU, HKLM or HKU\\<SID>", first)
+	}
+	if hive != HiveUsers {
+		return &RegistryKey{Hive: hive, Path: rest}, nil
+	}
+
+	sid, rest, _ := strings.Cut(rest, `\`)
+	if _, err := windows.StringToSid(sid); err != nil {
+		return nil, fmt.Errorf("registry hive HKU needs a user SID, as in HKU\\S-1-5-21-...: invalid SID '%s'", sid)
+	}
+	return &RegistryKey{Hive: HiveUsers + `\` + strings.ToUpper(sid), Path: rest}, nil
+}
+
+// String returns the full path of the key including its hive.
+func (k *RegistryKey) String() string {
+	return k.Hive + `\` + k.Path
+}
+
+// sid returns the user SID of a key in HKU, or "".
+func (k *RegistryKey) sid() string {
+	_, sid, _ := strings.Cut(k.Hive, `\`)
+	return sid
+}
+
+// requirement returns what writing the key needs: HKLM and the hives of
+// other users need an elevated administrator.
+func (k *RegistryKey) requirement() (Requirement, error) {
+	switch {
+	case k.Hive == HiveCurrentUser:
+		return RequireNothing, nil
+	case k.Hive == HiveLocalMachine:
+		return RequireElevation, nil
+	}
+
+	user, err := windows.GetCurrentProcessToken().GetTokenUser()
+	if err != nil {
+		return Requirement{}, fmt.Errorf("failed to query token user: %w", err)
+	}
+	if strings.EqualFold(user.User.Sid.String(), k.sid()) {
+		return RequireNothing, nil
+	}
+	return RequireElevation, nil
+}
+
+// root returns the predefined key and the path below it to open k. The hive
+// of an HKU key must be loaded, which it is while the user is logged on.
+func (k *RegistryKey) root() (registry.Key, string, error) {
+	switch k.Hive {
+	case HiveCurrentUser:
+		return registry.CURRENT_USER, k.Path, nil
+	case HiveLocalMachine:
+		return registry.LOCAL_MACHINE, k.Path, nil
+	}
+
+	sid := k.sid()
+	hive, err := registry.OpenKey(registry.USERS, sid, registry.QUERY_VALUE)
+	if errors.Is(err, windows.ERROR_FILE_NOT_FOUND) {
+		return 0, "", fmt.Errorf("%w: %s is not loaded, the user must be logged on", ErrHiveUnavailable, k.Hive)
+	}
+	if err != nil {
+		return 0, "", fmt.Errorf("%w: failed to open %s: %v", ErrHiveUnavailable, k.Hive, err)
+	}
+	hive.Close()
+	return registry.USERS, sid + `\` + k.Path, nil
+}
+
+// check fails early if the process may not write the key.
+func (k *RegistryKey) check(op string) error {
+	requirement, err := k.requirement()
+	if err != nil {
+		return err
+	}
+	return checkRequirement(op+" "+k.String(), requirement)
+}
+
+// registryError adds a hint to access denied errors on the key.
+func (k *RegistryKey) registryError(op string, err error) error {
+	if errors.Is(err, windows.ERROR_ACCESS_DENIED) {
+		return fmt.Errorf("failed to %s '%s': access denied, GoPersist may not write this key as the current user: %w", op, k, err)
+	}
+	return fmt.Errorf("failed to %s '%s': %w", op, k, err)
+}
@@ -0,0 +1,69 @@
+//go:build windows
+
+package persist
+
+import (
+	"strings"
+	"testing"
+)
+
+const testUserSID = "S-1-5-21-1004336348-1177238915-682003330-1001"
+
+func TestParseRegistryKey(t *testing.T) {
+	tests := []struct {
+		hive, path string
+		want       RegistryKey
+	}{
+		{"", `Software\Tool`, RegistryKey{HiveCurrentUser, `Software\Tool`}},
+		{"", `\Software\Tool\`, RegistryKey{HiveCurrentUser, `Software\Tool`}},
+		{"", `HKLM\Software\Tool`, RegistryKey{HiveLocalMachine, `Software\Tool`}},
+		{"", `hkey_local_machine\Software\Tool`, RegistryKey{HiveLocalMachine, `Software\Tool`}},
+		{"HKLM", `Software\Tool`, RegistryKey{HiveLocalMachine, `Software\Tool`}},
+		{"HKEY_CURRENT_USER", `HKCU\Software\Tool`, RegistryKey{HiveCurrentUser, `Software\Tool`}},
+		{"", `hku\` + testUserSID + `\Software`, RegistryKey{HiveUsers + `\` + testUserSID, "Software"}},
+		{`HKU\` + testUserSID, "Software", RegistryKey{HiveUsers + `\` + testUserSID, "Software"}},
+	}
+	for _, tt := range tests {
+		got, err := ParseRegistryKey(tt.hive, tt.path)
+		if err != nil {
+			t.Errorf("ParseRegistryKey(%q, %q): %v", tt.hive, tt.path, err)
+			continue
+		}
+		if *got != tt.want {
+			t.Errorf("ParseRegistryKey(%q, %q) = %+v, want %+v", tt.hive, tt.path, *got, tt.want)
+		}
+	}
+}
+
+func TestParseRegistryKeyErrors(t *testing.T) {
+	tests := []struct {
+		hive, path, want string
+	}{
+		// Hive and path conflict
+		{"HKCU", `HKLM\Software\Tool`, "is not in hive HKCU"},
+		{`HKU\` + testUserSID, `HKCU\Software`, "is not in hive"},
+		// HKU without a SID
+		{"", `HKU\Software\Tool`, "needs a user SID"},
+		{"HKU", "Software", "needs a user SID"},
+		// No path below the hive
+		{"", `HKLM`, "has no path below its hive"},
+		{"", `HKLM\`, "has no path below its hive"},
+		{"HKCU", "", "has no path below its hive"},
+		{"", `HKU\` + testUserSID, "has no path below its hive"},
+		// Unsupported hives
+		{"", `HKCR\.txt`, "unsupported registry hive 'HKCR'"},
+		{"", `HKEY_CLASSES_ROOT\.txt`, "unsupported registry hive 'HKEY_CLASSES_ROOT'"},
+		{"HKCR", "Software", "unknown registry hive 'HKCR'"},
+		{`HKLM\Software`, "Tool", "invalid registry hive"},
+	}
+	for _, tt := range tests {
+		got, err := ParseRegistryKey(tt.hive, tt.path)
+		if err == nil {
+			t.Errorf("ParseRegistryKey(%q, %q) = %+v, want an error", tt.hive, tt.path, *got)
+			continue
+		}
+		if !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("ParseRegistryKey(%q, %q) error %q, want it to contain %q", tt.hive, tt.path, err, tt.want)
+		}
+	}
+}
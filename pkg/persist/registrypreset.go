@@ -0,0 +1,284 @@
+//go:build windows
+
+package persist
+
+import (
+	"fmt"
+	"golang.org/x/sys/windows/registry"
+	"sort"
+	"strings"
+)
+
+// RegistryPreset is a well-known registry autostart location.
+type RegistryPreset struct {
+	Name string
+	// Executes describes how and when Windows runs the entry.
+	Executes string
+	// Hives lists the hives the location works in, the default first. HKCU
+	// also stands for the HKU\<SID> hives of other users.
+	Hives []string
+	Path  string
+	// Value is the fixed value name, or "" if any value name works.
+	Value string
+	// Type is the value type Windows expects: registry.SZ,
+	// registry.EXPAND_SZ or registry.MULTI_SZ.
+	Type uint32
+	// Format is the format of the data, %s standing for the command line.
+	// Empty means the command line itself.
+	Format string
+	// Separator separates the entries of a value Windows keeps a list in,
+	// such as Winlogon Userinit. Such values are shared with Windows and
+	// must be appended to rather than overwritten. MULTI_SZ values are lists
+	// without a separator.
+	Separator string
+	// Cmd is set if cmd.exe runs the data as a command line, which is then
+	// escaped for cmd.exe.
+	Cmd bool
+}
+
+// registryPresets is the catalog of registry autostart locations.
+var registryPresets = []*RegistryPreset{
+	{
+		Name:     "run",
+		Executes: "at every logon of the user, or of any user in HKLM",
+		Hives:    []string{HiveCurrentUser, HiveLocalMachine},
+		Path:     `Software\Microsoft\Windows\CurrentVersion\Run`,
+		Type:     registry.SZ,
+	},
+	{
+		Name:     "runonce",
+		Executes: "once at the next logon, Windows deletes the value before running it",
+		Hives:    []string{HiveCurrentUser, HiveLocalMachine},
+		Path:     `Software\Microsoft\Windows\CurrentVersion\RunOnce`,
+		Type:     registry.SZ,
+	},
+	{
+		Name:     "runonceex",
+		Executes: "once at the next logon by runonce.exe, where RunOnceEx processing is enabled",
+		Hives:    []string{HiveLocalMachine},
+		Path:     `Software\Microsoft\Windows\CurrentVersion\RunOnceEx\0001`,
+		Type:     registry.SZ,
+		Format:   "||%s",
+	},
+	{
+		Name:     "policies-run",
+		Executes: "at every logon, like run, from the Explorer policy key",
+		Hives:    []string{HiveCurrentUser, HiveLocalMachine},
+		Path:     `Software\Microsoft\Windows\CurrentVersion\Policies\Explorer\Run`,
+		Type:     registry.SZ,
+	},
+	{
+		Name:      "winlogon-userinit",
+		Executes:  "at every logon of any user, started by winlogon.exe before the shell",
+		Hives:     []string{HiveLocalMachine},
+		Path:      `Software\Microsoft\Windows NT\CurrentVersion\Winlogon`,
+		Value:     "Userinit",
+		Type:      registry.SZ,
+		Separator: ",",
+	},
+	{
+		Name:      "winlogon-shell",
+		Executes:  "at every logon of any user, started by winlogon.exe as part of the shell",
+		Hives:     []string{HiveLocalMachine},
+		Path:      `Software\Microsoft\Windows NT\CurrentVersion\Winlogon`,
+		Value:     "Shell",
+		Type:      registry.SZ,
+		Separator: ",",
+	},
+	{
+		Name:      "windows-load",
+		Executes:  "at every logon of the user, started by explorer.exe",
+		Hives:     []string{HiveCurrentUser},
+		Path:      `Software\Microsoft\Windows NT\CurrentVersion\Windows`,
+		Value:     "Load",
+		Type:      registry.SZ,
+		Separator: " ",
+	},
+	{
+		Name:     "cmd-autorun",
+		Executes: "whenever cmd.exe starts, unless started with /d",
+		Hives:    []string{HiveCurrentUser, HiveLocalMachine},
+		Path:     `Software\Microsoft\Command Processor`,
+		Value:    "AutoRun",
+		Type:     registry.EXPAND_SZ,
+		Cmd:      true,
+	},
+	{
+		Name:     "logon-script",
+		Executes: "at every logon of the user, as the user's logon script",
+		Hives:    []string{HiveCurrentUser},
+		Path:     `Environment`,
+		Value:    "UserInitMprLogonScript",
+		Type:     registry.SZ,
+	},
+	{
+		Name:     "bootexecute",
+		Executes: "at boot by the Session Manager, before Windows starts; native executables only",
+		Hives:    []string{HiveLocalMachine},
+		Path:     `System\CurrentControlSet\Control\Session Manager`,
+		Value:    "BootExecute",
+		Type:     registry.MULTI_SZ,
+	},
+}
+
+// registryValueTypes names the registry value types GoPersist writes.
+var registryValueTypes = map[uint32]string{
+	registry.SZ:        "REG_SZ",
+	registry.EXPAND_SZ: "REG_EXPAND_SZ",
+	registry.MULTI_SZ:  "REG_MULTI_SZ",
+}
+
+// RegistryPresets returns the catalog of registry autostart locations,
+// ordered by name.
+func RegistryPresets() []*RegistryPreset {
+	presets := append([]*RegistryPreset(nil), registryPresets...)
+	sort.Slice(presets, func(i, j int) bool { return presets[i].Name < presets[j].Name })
+	return presets
+}
+
+// LookupRegistryPreset returns the preset name.
+func LookupRegistryPreset(name string) (*RegistryPreset, error) {
+	for _, p := range registryPresets {
+		if strings.EqualFold(p.Name, name) {
+			return p, nil
+		}
+	}
+	names := make([]string, len(registryPresets))
+	for i, p := range registryPresets {
+		names[i] = p.Name
+	}
+	return nil, fmt.Errorf("unknown registry preset %q, expected one of %s", name, strings.Join(names, ", "))
+}
+
+// Key returns the key of the preset in hive, or in its default hive if hive
+// is empty.
+func (p *RegistryPreset) Key(hive string) (*RegistryKey, error) {
+	if hive == "" {
+		hive = p.Hives[0]
+	}
+	key, err := ParseRegistryKey(hive, p.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	// HKU\<SID> hives take the keys of HKCU
+	kind, _, _ := strings.Cut(key.Hive, `\`)
+	if kind == HiveUsers {
+		kind = HiveCurrentUser
+	}
+	for _, h := range p.Hives {
+		if h == kind {
+			return key, nil
+		}
+	}
+	return nil, fmt.Errorf("registry preset %s only works in %s", p.Name, strings.Join(p.Hives, " or "))
+}
+
+// RegistryValue returns the value to write for the preset: the fixed value
+// of the preset, or the value name. A name differing from the fixed value is
+// an error. List values are appended to.
+func (p *RegistryPreset) RegistryValue(name string) (*RegistryValue, error) {
+	switch {
+	case p.Value == "":
+		if name == "" {
+			return nil, fmt.Errorf("registry preset %s needs a value name", p.Name)
+		}
+	case name != "" && !strings.EqualFold(name, p.Value):
+		return nil, fmt.Errorf("registry preset %s always writes the value %s", p.Name, p.Value)
+	default:
+		name = p.Value
+	}
+	return &RegistryValue{
+		Name:      name,
+		Type:      p.Type,
+		Append:    p.Separator != "" || p.Type == registry.MULTI_SZ,
+		Separator: p.Separator,
+	}, nil
+}
+
+// Admin reports whether writing the preset in hive, or in its default hive
+// if hive is empty, needs an elevated administrator. The hives of the
+// current user do not.
+func (p *RegistryPreset) Admin(hive string) (bool, error) {
+	key, err := p.Key(hive)
+	if err != nil {
+		return false, err
+	}
+	requirement, err := key.requirement()
+	if err != nil {
+		return false, err
+	}
+	return requirement.Elevated, nil
+}
+
+// String describes the preset for the catalog listing.
+func (p *RegistryPreset) String() string {
+	value := p.Value
+	if value == "" {
+		value = "<any>"
+	}
+	admin := make([]string, len(p.Hives))
+	for i, hive := range p.Hives {
+		admin[i] = hive + " no"
+		if elevated, err := p.Admin(hive); err != nil || elevated {
+			admin[i] = hive + " yes"
+		}
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s\n", p.Name)
+	fmt.Fprintf(&b, "  Key:      %s\\%s\n", strings.Join(p.Hives, "|"), p.Path)
+	fmt.Fprintf(&b, "  Value:    %s (%s)\n", value, registryValueTypes[p.Type])
+	fmt.Fprintf(&b, "  Admin:    %s\n", strings.Join(admin, ", "))
+	fmt.Fprintf(&b, "  Executes: %s", p.Executes)
+	return b.String()
+}
+
+// AddRegistryPreset writes an entry running command with args to the preset
+// location in hive, or in its default hive if hive is empty. valueName is
+// used for presets without a fixed value name. Entries of list values are
+// appended, leaving the entries of Windows in place.
+func AddRegistryPreset(p *RegistryPreset, hive, valueName, command string, args []string) error {
+	key, err := p.Key(hive)
+	if err != nil {
+		return err
+	}
+	value, err := p.RegistryValue(valueName)
+	if err != nil {
+		return err
+	}
+
+	build := BuildCommandLine
+	if p.Cmd {
+		build = BuildCmdCommandLine
+	}
+	cmdline, err := build(command, args)
+	if err != nil {
+		return fmt.Errorf("invalid command: %w", err)
+	}
+	data := cmdline
+	if p.Format != "" {
+		data = fmt.Sprintf(p.Format, cmdline)
+	}
+
+	// Windows splits a space separated list such as Load on every space, so a
+	// quoted path or arguments would be taken for separate programs
+	if p.Separator == " " && strings.ContainsAny(data, " \t") {
+		return fmt.Errorf("registry preset %s separates programs with spaces, so its command can take no arguments and its path cannot contain spaces", p.Name)
+	}
+
+	return writeRegistryEntry(key, value, data)
+}
+
+// RemoveRegistryPreset removes the entry AddRegistryPreset wrote. From list
+// values only the appended entry is stripped.
+func RemoveRegistryPreset(p *RegistryPreset, hive, valueName string) error {
+	key, err := p.Key(hive)
+	if err != nil {
+		return err
+	}
+	value, err := p.RegistryValue(valueName)
+	if err != nil {
+		return err
+	}
+	return RemoveRegistryPersistence(key, value)
+}
@@ -0,0 +1,141 @@
+package persist
+
+import (
+	"sort"
+	"strings"
+)
+
+// registryAppend is an entry GoPersist appended to a registry value Windows
+// keeps a list in, such as Winlogon Userinit.
+type registryAppend struct {
+	// Key is the full key path including the hive.
+	Key   string `json:"key"`
+	Value string `json:"value"`
+	Entry string `json:"entry"`
+	// Separator separates the entries of a string value, it is empty for
+	// REG_MULTI_SZ values.
+	Separator string `json:"separator,omitempty"`
+	// Created is set if the value did not exist before the entry was
+	// appended.
+	Created bool `json:"created,omitempty"`
+}
+
+// recordRegistryAppend records an appended entry.
+func (st *persistState) recordRegistryAppend(a registryAppend) {
+	st.forgetRegistryAppend(a)
+	st.RegistryAppends = append(st.RegistryAppends, a)
+}
+
+// registryAppends returns the entries GoPersist appended to the value of
+// key.
+func (st *persistState) registryAppends(key, value string) []registryAppend {
+	var appends []registryAppend
+	for _, a := range st.RegistryAppends {
+		if strings.EqualFold(a.Key, key) && strings.EqualFold(a.Value, value) {
+			appends = append(appends, a)
+		}
+	}
+	return appends
+}
+
+// forgetRegistryAppend removes the record of an appended entry.
+func (st *persistState) forgetRegistryAppend(a registryAppend) {
+	for i, recorded := range st.RegistryAppends {
+		if strings.EqualFold(recorded.Key, a.Key) && strings.EqualFold(recorded.Value, a.Value) && recorded.Entry == a.Entry {
+			st.RegistryAppends = append(st.RegistryAppends[:i], st.RegistryAppends[i+1:]...)
+			return
+		}
+	}
+}
+
+// recordRegistryKey records a registry key GoPersist created.
+func (st *persistState) recordRegistryKey(path string) {
+	for _, recorded := range st.RegistryKeys {
+		if strings.EqualFold(recorded, path) {
+			return
+		}
+	}
+	st.RegistryKeys = append(st.RegistryKeys, path)
+}
+
+// createdRegistryKeys returns the recorded keys GoPersist created that are
+// key itself or one of its parents, deepest first.
+func (st *persistState) createdRegistryKeys(key string) []string {
+	var keys []string
+	for _, recorded := range st.RegistryKeys {
+		if strings.EqualFold(recorded, key) || strings.HasPrefix(strings.ToLower(key), strings.ToLower(recorded)+`\`) {
+			keys = append(keys, recorded)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool { return len(keys[i]) > len(keys[j]) })
+	return keys
+}
+
+// forgetRegistryKey removes the record of a created registry key.
+func (st *persistState) forgetRegistryKey(path string) {
+	for i, recorded := range st.RegistryKeys {
+		if strings.EqualFold(recorded, path) {
+			st.RegistryKeys = append(st.RegistryKeys[:i], st.RegistryKeys[i+1:]...)
+			return
+		}
+	}
+}
+
+// listContains reports whether the list data separated by sep contains
+// entry. Blanks around entries are ignored.
+func listContains(data, sep, entry string) bool {
+	for _, e := range strings.Split(data, sep) {
+		if strings.TrimSpace(e) == entry {
+			return true
+		}
+	}
+	return false
+}
+
+// appendListEntry appends entry to the list data separated by sep. A
+// trailing separator, as in the default Userinit value, is kept after the
+// new entry.
+func appendListEntry(data, sep, entry string) string {
+	switch {
+	case strings.TrimSpace(data) == "":
+		return entry
+	case sep != " " && strings.HasSuffix(strings.TrimRight(data, " "), sep):
+		return data + entry + sep
+	}
+	return data + sep + entry
+}
+
+// removeListEntry removes the first occurrence of entry from the list data
+// separated by sep, together with the separator appendListEntry added with
+// it, leaving the rest of data byte for byte as it was. found is false if the
+// list does not contain entry.
+func removeListEntry(data, sep, entry string) (result string, found bool) {
+	start := 0
+	for {
+		end := strings.Index(data[start:], sep)
+		last := end < 0
+		if last {
+			end = len(data)
+		} else {
+			end += start
+		}
+
+		if strings.TrimSpace(data[start:end]) == entry {
+			if !last {
+				// Drop the entry and the separator after it, keeping the
+				// blanks before it
+				from := start + strings.Index(data[start:end], entry)
+				return data[:from] + data[end+len(sep):], true
+			}
+			// Drop the last entry with the separator before it
+			if start == 0 {
+				return "", true
+			}
+			return data[:start-len(sep)], true
+		}
+		if last {
+			return data, false
+		}
+		start = end + len(sep)
+	}
+}
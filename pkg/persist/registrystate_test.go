@@ -0,0 +1,91 @@
+package persist
+
+import "testing"
+
+const userinitDefault = `C:\Windows\system32\userinit.exe,`
+
+func TestListContains(t *testing.T) {
+	tests := []struct {
+		data, sep, entry string
+		want             bool
+	}{
+		{userinitDefault, ",", `C:\Windows\system32\userinit.exe`, true},
+		{userinitDefault, ",", `C:\Tools\x.exe`, false},
+		{userinitDefault + ` C:\Tools\x.exe,`, ",", `C:\Tools\x.exe`, true},
+		{"explorer.exe x.exe", " ", "x.exe", true},
+		{"explorer.exe  x.exe ", " ", "x.exe", true},
+		{"explorer.exe x.exe2", " ", "x.exe", false},
+		{"", ",", "x.exe", false},
+	}
+	for _, tt := range tests {
+		if got := listContains(tt.data, tt.sep, tt.entry); got != tt.want {
+			t.Errorf("listContains(%q, %q, %q) = %v, want %v", tt.data, tt.sep, tt.entry, got, tt.want)
+		}
+	}
+}
+
+func TestAppendListEntry(t *testing.T) {
+	tests := []struct {
+		data, sep, want string
+	}{
+		{"", ",", `C:\Tools\x.exe`},
+		{"  ", " ", `C:\Tools\x.exe`},
+		{userinitDefault, ",", userinitDefault + `C:\Tools\x.exe,`},
+		{`C:\Windows\system32\userinit.exe, `, ",", `C:\Windows\system32\userinit.exe, C:\Tools\x.exe,`},
+		{"explorer.exe", ",", `explorer.exe,C:\Tools\x.exe`},
+		{"a.exe", " ", `a.exe C:\Tools\x.exe`},
+		{"a.exe ", " ", `a.exe  C:\Tools\x.exe`},
+	}
+	for _, tt := range tests {
+		if got := appendListEntry(tt.data, tt.sep, `C:\Tools\x.exe`); got != tt.want {
+			t.Errorf("appendListEntry(%q, %q) = %q, want %q", tt.data, tt.sep, got, tt.want)
+		}
+	}
+}
+
+func TestRemoveListEntry(t *testing.T) {
+	tests := []struct {
+		data, sep, entry, want string
+		found                  bool
+	}{
+		{userinitDefault + `C:\Tools\x.exe,`, ",", `C:\Tools\x.exe`, userinitDefault, true},
+		{`C:\Tools\x.exe,a.exe`, ",", `C:\Tools\x.exe`, "a.exe", true},
+		{"a.exe x.exe b.exe", " ", "x.exe", "a.exe b.exe", true},
+		{"a.exe x.exe", " ", "x.exe", "a.exe", true},
+		{"x.exe", " ", "x.exe", "", true},
+		{userinitDefault, ",", `C:\Tools\x.exe`, userinitDefault, false},
+		{"a.exe x.exe2", " ", "x.exe", "a.exe x.exe2", false},
+	}
+	for _, tt := range tests {
+		got, found := removeListEntry(tt.data, tt.sep, tt.entry)
+		if got != tt.want || found != tt.found {
+			t.Errorf("removeListEntry(%q, %q, %q) = %q, %v, want %q, %v", tt.data, tt.sep, tt.entry, got, found, tt.want, tt.found)
+		}
+	}
+}
+
+func TestListEntryRoundTrip(t *testing.T) {
+	tests := []struct {
+		data, sep string
+	}{
+		{userinitDefault, ","},
+		{`C:\Windows\system32\userinit.exe, `, ","},
+		{`C:\Windows\system32\userinit.exe`, ","},
+		{"explorer.exe", ","},
+		{"", ","},
+		{"", " "},
+		{"a.exe", " "},
+		{"a.exe b.exe", " "},
+		{"a.exe ", " "},
+	}
+	for _, tt := range tests {
+		appended := appendListEntry(tt.data, tt.sep, `C:\Tools\x.exe`)
+		if !listContains(appended, tt.sep, `C:\Tools\x.exe`) {
+			t.Errorf("appendListEntry(%q, %q) = %q does not contain the entry", tt.data, tt.sep, appended)
+		}
+		got, found := removeListEntry(appended, tt.sep, `C:\Tools\x.exe`)
+		if !found || got != tt.data {
+			t.Errorf("removeListEntry(appendListEntry(%q, %q)) = %q, %v, want the original", tt.data, tt.sep, got, found)
+		}
+	}
+}
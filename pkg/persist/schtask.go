@@ -1,208 +1,283 @@
+//go:build windows
+
 package persist
 
 import (
 	"fmt"
+	"github.com/go-ole/go-ole"
 	"github.com/go-ole/go-ole/oleutil"
+	"os/user"
 	"time"
 )
 
-type SchTask struct {
-	taskName   string
-	command    string
-	commandArg string
-	trigger    string
-}
-
-func NewSchTask(taskName, command, commandArg, trigger string) *SchTask {
-	return &SchTask{
-		taskName:   taskName,
-		command:    command,
-		commandArg: commandArg,
-		trigger:    trigger,
-	}
-}
-
+// CreateTask registers the task definition with Task Scheduler as XML.
 func (s *SchTask) CreateTask() error {
-	ole.CoInitialize(0)
-	defer ole.CoUninitialize()
-
-	unknown, err := oleutil.CreateObject("Schedule.Service")
+	// Render the task definition as XML
+	taskXML, err := s.XML()
 	if err != nil {
 		return err
 	}
-	defer unknown.Release()
 
-	service, err := unknown.QueryInterface(ole.IID_IDispatch)
-	if err != nil {
+	// Check the caller may register a task for the principal
+	principal := s.Principal()
+	if err := principal.checkPassword(); err != nil {
 		return err
 	}
-	defer service.Release()
-
-	_, err = oleutil.CallMethod(service, "Connect")
-	if err != nil {
+	if err := checkPrincipalPrivileges(principal); err != nil {
 		return err
 	}
 
-	taskFolder, err := oleutil.CallMethod(service, "GetFolder", "\\")
+	ts, err := NewTaskScheduler()
 	if err != nil {
 		return err
 	}
-	defer taskFolder.ToIDispatch().Release()
+	defer ts.Close()
 
-	// Create Task Definition
-	taskDef, err := oleutil.CallMethod(service, "NewTask", 0)
-	if err != nil {
+	if err := ts.Register(s.taskName, taskXML, principal); err != nil {
 		return err
 	}
-	defer taskDef.ToIDispatch().Release()
 
-	// Set Task Settings
-	//settings, err := oleutil.GetProperty(taskDef.ToIDispatch(), "Settings")
-	//if err != nil {
-	//	return err
-	//}
-	//oleutil.PutProperty(settings.ToIDispatch(), "DisallowStartIfOnBatteries", false)
-	//oleutil.PutProperty(settings.ToIDispatch(), "StopIfGoingOnBatteries", false)
+	fmt.Printf("Task '%s' created successfully!\n", s.taskName)
+	return nil
+}
 
-	// Set Task Trigger based on options
-	triggerCollection, err := oleutil.GetProperty(taskDef.ToIDispatch(), "Triggers")
+// RemoveTask deletes the task. If SetRemoveEmptyFolders was called, the
+// folders of the task that GoPersist created are deleted too once empty.
+func (s *SchTask) RemoveTask() error {
+	ts, err := NewTaskScheduler()
 	if err != nil {
 		return err
 	}
-	defer triggerCollection.ToIDispatch().Release()
-
-	switch s.trigger {
-	case "daily":
-		trigger, err := oleutil.CallMethod(triggerCollection.ToIDispatch(), "Create", 1) // 1 is daily trigger type
-		if err != nil {
-			return err
-		}
-		triggerDispatch := trigger.ToIDispatch()
-		defer triggerDispatch.Release()
-
-		startTime := time.Now().Add(10 * time.Hour)
-		oleutil.PutProperty(triggerDispatch, "StartBoundary", startTime.Format(time.RFC3339))
-		oleutil.PutProperty(triggerDispatch, "DaysInterval", 1)
+	defer ts.Close()
 
-	case "hourly":
-		trigger, err := oleutil.CallMethod(triggerCollection.ToIDispatch(), "Create", 1) // Time Trigger
-		if err != nil {
-			return err
-		}
-		triggerDispatch := trigger.ToIDispatch()
-		defer triggerDispatch.Release()
-
-		oleutil.PutProperty(triggerDispatch, "Repetition.Interval", "PT1H") // every hour
-
-	case "logon":
-		trigger, err := oleutil.CallMethod(triggerCollection.ToIDispatch(), "Create", 9) // 9 is logon trigger type
-		if err != nil {
-			return err
-		}
-		triggerDispatch := trigger.ToIDispatch()
-		defer triggerDispatch.Release()
+	if err := ts.Delete(s.taskName, s.removeFolders); err != nil {
+		return err
 	}
 
-	// Set Action
-	actionCollection, err := oleutil.GetProperty(taskDef.ToIDispatch(), "Actions")
+	fmt.Printf("Task '%s' deleted successfully!\n", s.taskName)
+	return nil
+}
+
+func (s *SchTask) CheckTask() error {
+	ts, err := NewTaskScheduler()
 	if err != nil {
 		return err
 	}
-	defer actionCollection.ToIDispatch().Release()
+	defer ts.Close()
 
-	action, err := oleutil.CallMethod(actionCollection.ToIDispatch(), "Create", 0) // 0 is action type execute
+	exists, err := ts.Exists(s.taskName)
 	if err != nil {
 		return err
 	}
-	actionDispatch := action.ToIDispatch()
-	defer actionDispatch.Release()
-
-	oleutil.PutProperty(actionDispatch, "Path", s.command)
-	if s.commandArg != "" {
-		oleutil.PutProperty(actionDispatch, "Arguments", s.commandArg)
+	if !exists {
+		fmt.Printf("Task '%s' does not exist.\n", s.taskName)
+		return nil
 	}
 
-	// Register Task
-	rootFolder := taskFolder.ToIDispatch()
-	_, err = oleutil.CallMethod(rootFolder, "RegisterTaskDefinition", s.taskName, taskDef.ToIDispatch(), 6, nil, nil, 3) // 6 = replace existing task, 3 = highest privilege
+	fmt.Printf("Task '%s' exists!\n", s.taskName)
+	return nil
+}
+
+// RunTask starts the task immediately. params are passed to its actions as
+// $(Arg0), $(Arg1), ...
+func (s *SchTask) RunTask(params []string) error {
+	ts, err := NewTaskScheduler()
 	if err != nil {
 		return err
 	}
+	defer ts.Close()
 
-	fmt.Printf("Task '%s' created successfully!\n", s.taskName)
+	if err := ts.Run(s.taskName, params); err != nil {
+		return err
+	}
+
+	fmt.Printf("Task '%s' started.\n", s.taskName)
 	return nil
 }
 
-func (s *SchTask) RemoveTask() error {
-	ole.CoInitialize(0)
-	defer ole.CoUninitialize()
-
-	unknown, err := oleutil.CreateObject("Schedule.Service")
+// StopTask stops all running instances of the task.
+func (s *SchTask) StopTask() error {
+	ts, err := NewTaskScheduler()
 	if err != nil {
 		return err
 	}
-	defer unknown.Release()
+	defer ts.Close()
 
-	service, err := unknown.QueryInterface(ole.IID_IDispatch)
-	if err != nil {
+	if err := ts.Stop(s.taskName); err != nil {
 		return err
 	}
-	defer service.Release()
 
-	_, err = oleutil.CallMethod(service, "Connect")
+	fmt.Printf("Task '%s' stopped.\n", s.taskName)
+	return nil
+}
+
+// SetTaskEnabled enables or disables the registered task.
+func (s *SchTask) SetTaskEnabled(enabled bool) error {
+	ts, err := NewTaskScheduler()
 	if err != nil {
 		return err
 	}
+	defer ts.Close()
 
-	taskFolder, err := oleutil.CallMethod(service, "GetFolder", "\\")
-	if err != nil {
+	if err := ts.SetEnabled(s.taskName, enabled); err != nil {
 		return err
 	}
-	defer taskFolder.ToIDispatch().Release()
 
-	_, err = oleutil.CallMethod(taskFolder.ToIDispatch(), "DeleteTask", s.taskName, 0)
+	fmt.Printf("Task '%s' enabled: %t\n", s.taskName, enabled)
+	return nil
+}
+
+// Status returns the state, last and next run of the registered task.
+func (s *SchTask) Status() (*TaskStatus, error) {
+	ts, err := NewTaskScheduler()
 	if err != nil {
-		return err
+		return nil, err
 	}
+	defer ts.Close()
 
-	fmt.Printf("Task '%s' deleted successfully!\n", s.taskName)
-	return nil
+	return ts.Status(s.taskName)
 }
 
-func (s *SchTask) CheckTask() error {
-	ole.CoInitialize(0)
-	defer ole.CoUninitialize()
+// readTaskStatus reads the runtime properties of a registered task.
+func readTaskStatus(task *ole.IDispatch) (*TaskStatus, error) {
+	status := &TaskStatus{}
+	for _, prop := range []string{"Path", "State", "Enabled", "LastRunTime", "LastTaskResult", "NextRunTime", "NumberOfMissedRuns"} {
+		value, err := oleutil.GetProperty(task, prop)
+		if err != nil {
+			return nil, taskError("read task "+prop, err)
+		}
 
-	unknown, err := oleutil.CreateObject("Schedule.Service")
+		switch prop {
+		case "Path":
+			status.Path = value.ToString()
+		case "State":
+			status.State = taskStateNames[int(value.Val)]
+		case "Enabled":
+			status.Enabled, _ = value.Value().(bool)
+		case "LastRunTime":
+			status.LastRunTime, _ = value.Value().(time.Time)
+		case "LastTaskResult":
+			status.LastTaskResult = uint32(value.Val)
+		case "NextRunTime":
+			status.NextRunTime, _ = value.Value().(time.Time)
+		case "NumberOfMissedRuns":
+			status.NumberOfMissedRuns = int(value.Val)
+		}
+		value.Clear()
+	}
+	return status, nil
+}
+
+// ensureTaskFolder opens the task folder at path, creating any missing
+// folders on the way. It returns the folders it created, even on error.
+func ensureTaskFolder(service *ole.IDispatch, path string) (*ole.IDispatch, []string, error) {
+	root, err := oleutil.CallMethod(service, "GetFolder", `\`)
 	if err != nil {
-		return err
+		return nil, nil, taskError(`open task folder \`, err)
+	}
+
+	current := root.ToIDispatch()
+	var created []string
+	for _, folder := range taskFolderChain(path) {
+		next, err := oleutil.CallMethod(service, "GetFolder", folder)
+		if err != nil {
+			_, name := parentTaskFolder(folder)
+			next, err = oleutil.CallMethod(current, "CreateFolder", name, nil)
+			if err != nil {
+				current.Release()
+				return nil, created, taskError("create task folder "+folder, err)
+			}
+			created = append(created, folder)
+			fmt.Printf("Task folder '%s' created.\n", folder)
+		}
+		current.Release()
+		current = next.ToIDispatch()
 	}
-	defer unknown.Release()
+	return current, created, nil
+}
 
-	service, err := unknown.QueryInterface(ole.IID_IDispatch)
+// removeCreatedTaskFolders deletes the folders of path that GoPersist
+// created, deepest first, stopping at the first folder that is not empty or
+// was not created by GoPersist.
+func removeCreatedTaskFolders(service *ole.IDispatch, path string) error {
+	st, err := loadState()
 	if err != nil {
 		return err
 	}
-	defer service.Release()
 
-	_, err = oleutil.CallMethod(service, "Connect")
+	chain := taskFolderChain(path)
+	for i := len(chain) - 1; i >= 0; i-- {
+		folder := chain[i]
+		if !st.createdTaskFolder(folder) {
+			break
+		}
+		empty, err := taskFolderEmpty(service, folder)
+		if err != nil {
+			return err
+		}
+		if !empty {
+			fmt.Printf("Task folder '%s' is not empty, keeping it.\n", folder)
+			break
+		}
+
+		parentPath, name := parentTaskFolder(folder)
+		parent, err := oleutil.CallMethod(service, "GetFolder", parentPath)
+		if err != nil {
+			return taskError("open task folder "+parentPath, err)
+		}
+		_, err = oleutil.CallMethod(parent.ToIDispatch(), "DeleteFolder", name, 0)
+		parent.ToIDispatch().Release()
+		if err != nil {
+			return taskError("delete task folder "+folder, err)
+		}
+
+		st.forgetTaskFolder(folder)
+		fmt.Printf("Task folder '%s' deleted.\n", folder)
+	}
+	return st.save()
+}
+
+// taskFolderEmpty reports whether a task folder has no tasks, including
+// hidden ones, and no subfolders.
+func taskFolderEmpty(service *ole.IDispatch, path string) (bool, error) {
+	folder, err := oleutil.CallMethod(service, "GetFolder", path)
 	if err != nil {
-		return err
+		return false, taskError("open task folder "+path, err)
 	}
+	defer folder.ToIDispatch().Release()
 
-	taskFolder, err := oleutil.CallMethod(service, "GetFolder", "\\")
+	tasks, err := oleutil.CallMethod(folder.ToIDispatch(), "GetTasks", 1) // 1 = include hidden tasks
 	if err != nil {
-		return err
+		return false, taskError("list task folder "+path, err)
+	}
+	defer tasks.ToIDispatch().Release()
+	taskCount, err := oleutil.GetProperty(tasks.ToIDispatch(), "Count")
+	if err != nil {
+		return false, taskError("list task folder "+path, err)
 	}
-	defer taskFolder.ToIDispatch().Release()
 
-	task, err := oleutil.CallMethod(taskFolder.ToIDispatch(), "GetTask", s.taskName)
+	folders, err := oleutil.CallMethod(folder.ToIDispatch(), "GetFolders", 0)
 	if err != nil {
-		fmt.Printf("Task '%s' does not exist.\n", s.taskName)
-		return nil
+		return false, taskError("list task folder "+path, err)
+	}
+	defer folders.ToIDispatch().Release()
+	folderCount, err := oleutil.GetProperty(folders.ToIDispatch(), "Count")
+	if err != nil {
+		return false, taskError("list task folder "+path, err)
 	}
-	defer task.ToIDispatch().Release()
 
-	fmt.Printf("Task '%s' exists!\n", s.taskName)
-	return nil
+	return taskCount.Val == 0 && folderCount.Val == 0, nil
+}
+
+// checkPrincipalPrivileges returns an error if registering a task for the
+// principal needs an elevated administrator and the process is not elevated.
+func checkPrincipalPrivileges(principal *TaskPrincipal) error {
+	current, err := user.Current()
+	if err != nil {
+		return fmt.Errorf("failed to look up current user: %w", err)
+	}
+	if !principal.needsElevation(current.Username) {
+		return nil
+	}
+	return checkRequirement("register a task to run as "+describePrincipal(principal), RequireElevation)
 }
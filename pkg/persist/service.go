@@ -1,19 +1,34 @@
+//go:build windows
+
 package persist
 
 import (
+	"errors"
 	"fmt"
 	"golang.org/x/sys/windows"
-	_ "golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc"
 	"golang.org/x/sys/windows/svc/mgr"
 	"log"
 	"os"
-	"syscall"
 	"time"
-	"unsafe"
 )
 
-// CreateService creates a new Windows service
-func CreateService(serviceName, displayName, executablePath, args string) error {
+// serviceRequirement is what managing services needs: the SCM grants the
+// access GoPersist asks for only to elevated administrators.
+var serviceRequirement = RequireElevation
+
+// CreateService creates a new Windows service running executablePath with
+// args. opts may be nil for the defaults described at ServiceOptions.
+func CreateService(serviceName, executablePath string, args []string, opts *ServiceOptions) error {
+	// Fail early unless elevated, and able to set reboot recovery actions
+	requirement := serviceRequirement
+	if opts != nil && opts.Recovery != nil {
+		requirement = opts.Recovery.requirement()
+	}
+	if err := checkRequirement("create service "+serviceName, requirement); err != nil {
+		return err
+	}
+
 	// Open a handle to the Service Control Manager
 	m, err := mgr.Connect()
 	if err != nil {
@@ -28,241 +43,240 @@ func CreateService(serviceName, displayName, executablePath, args string) error
 		return fmt.Errorf("service %s already exists", serviceName)
 	}
 
-	// Create the service
-	s, err = m.CreateService(serviceName, executablePath, mgr.Config{
-		DisplayName:    displayName,
-		BinaryPathName: executablePath + " " + args,
-		StartType:      mgr.StartAutomatic,
-	}, []string{}...)
+	// Create the service. mgr quotes the binary path by rules of its own, so
+	// set the command line built by BuildCommandLine afterwards
+	cmdline, err := BuildCommandLine(executablePath, args)
+	if err != nil {
+		return fmt.Errorf("invalid service binary: %w", err)
+	}
+	s, err = m.CreateService(serviceName, executablePath, opts.config())
 	if err != nil {
 		return fmt.Errorf("failed to create service: %w", err)
 	}
 	defer s.Close()
+	err = windows.ChangeServiceConfig(s.Handle, windows.SERVICE_NO_CHANGE, windows.SERVICE_NO_CHANGE, windows.SERVICE_NO_CHANGE,
+		serviceStringPtr(cmdline), nil, nil, nil, nil, nil, nil)
+	if err != nil {
+		s.Delete()
+		return fmt.Errorf("failed to set binary path of service %s: %w", serviceName, err)
+	}
+
+	// Register the events that start or stop the service
+	if opts != nil && len(opts.Triggers) > 0 {
+		if err := setServiceTriggers(s.Handle, opts.Triggers); err != nil {
+			s.Delete()
+			return err
+		}
+	}
+
+	// Configure what the SCM does when the service fails
+	if opts != nil && opts.Recovery != nil {
+		if err := opts.Recovery.apply(s); err != nil {
+			s.Delete()
+			return err
+		}
+	}
 
 	fmt.Printf("Service %s created successfully.\n", serviceName)
 	return nil
 }
 
+// StartService starts the service serviceName and waits until it is
+// running. If the service stops instead, a *ServiceStoppedError with its exit
+// code is returned.
 func StartService(serviceName string) error {
-	// Convert the service name to UTF-16
-	serviceNamePtr, err := convertStringToUTF16Ptr(serviceName)
-	if err != nil {
-		return fmt.Errorf("failed to convert service name to UTF-16: %w", err)
+	// Fail early unless elevated
+	if err := checkRequirement("start service "+serviceName, serviceRequirement); err != nil {
+		return err
 	}
 
 	// Open a handle to the Service Control Manager
-	mgr, err := windows.OpenSCManager(nil, nil, windows.SC_MANAGER_ALL_ACCESS)
+	m, err := mgr.Connect()
 	if err != nil {
 		return fmt.Errorf("failed to connect to service control manager: %w", err)
 	}
-	defer windows.CloseServiceHandle(mgr)
+	defer m.Disconnect()
 
 	// Open a handle to the service
-	svc, err := windows.OpenService(mgr, serviceNamePtr, windows.SERVICE_START)
+	s, err := m.OpenService(serviceName)
 	if err != nil {
 		return fmt.Errorf("failed to open service %s: %w", serviceName, err)
 	}
-	defer windows.CloseServiceHandle(svc)
+	defer s.Close()
 
 	// Attempt to start the service
-	err = windows.StartService(svc, 0, nil)
+	err = s.Start()
 	if err != nil {
-		return fmt.Errorf("failed to start service %s: %w.", serviceName, err)
+		return fmt.Errorf("failed to start service %s: %w", serviceName, err)
 	}
 
 	// Wait for the service to start
-	timeout := time.After(30 * time.Second)
-	ticker := time.Tick(1 * time.Second)
-	for {
-		select {
-		case <-timeout:
-			return fmt.Errorf("timeout: service %s did not start in time", serviceName)
-		case <-ticker:
-			status, err := getServiceStatus(svc)
-			if err != nil {
-				return err
-			}
-			if status == windows.SERVICE_RUNNING {
-				fmt.Printf("Service %s started successfully.\n", serviceName)
-				return nil
-			}
-		}
+	if err := waitServiceState(s, svc.Running, serviceStartTimeout); err != nil {
+		return err
 	}
+	fmt.Printf("Service %s started successfully.\n", serviceName)
+	return nil
 }
 
-// getServiceStatus retrieves the current status of the service
-func getServiceStatus(svc windows.Handle) (uint32, error) {
-	var status windows.SERVICE_STATUS
-	err := windows.QueryServiceStatus(svc, &status)
-	if err != nil {
-		return 0, err
-	}
-	return status.CurrentState, nil
-}
+// ErrServiceDeletePending is returned by DeleteService if the service was
+// marked for deletion but is still registered, because another process holds
+// a handle to it. The SCM removes it once all handles are closed, at the
+// latest on reboot.
+var ErrServiceDeletePending = errors.New("service is marked for deletion")
 
-// convertStringToUTF16Ptr converts a Go string to a UTF-16 pointer.
-func convertStringToUTF16Ptr(s string) (*uint16, error) {
-	utf16, err := syscall.UTF16PtrFromString(s)
-	if err != nil {
-		return nil, err
-	}
-	return utf16, nil
-}
+// serviceStartTimeout is how long StartService waits for a service to run.
+const serviceStartTimeout = 30 * time.Second
+
+// serviceStopTimeout is how long DeleteService waits for a service to stop,
+// and for its SCM entry to disappear after deleting it.
+const serviceStopTimeout = 30 * time.Second
 
-// DeleteService attempts to delete a Windows service given its name.
+// DeleteService stops a Windows service given its name, together with the
+// services depending on it, deletes it and waits until the SCM has removed
+// it.
 func DeleteService(serviceName string) error {
-	// Convert the service name to UTF-16
-	serviceNamePtr, err := convertStringToUTF16Ptr(serviceName)
-	if err != nil {
-		return fmt.Errorf("failed to convert service name to UTF-16: %w", err)
+	// Fail early unless elevated
+	if err := checkRequirement("delete service "+serviceName, serviceRequirement); err != nil {
+		return err
 	}
 
 	// Open a handle to the Service Control Manager
-	mgr, err := windows.OpenSCManager(nil, nil, windows.SC_MANAGER_ALL_ACCESS)
+	m, err := mgr.Connect()
 	if err != nil {
 		return fmt.Errorf("failed to connect to service control manager: %w", err)
 	}
-	defer windows.CloseServiceHandle(mgr)
+	defer m.Disconnect()
 
-	// Open a handle to the service
-	svc, err := windows.OpenService(mgr, serviceNamePtr, windows.DELETE)
+	// Open a handle to the service. One already marked for deletion is left
+	// for the SCM to remove
+	s, err := m.OpenService(serviceName)
+	if errors.Is(err, windows.ERROR_SERVICE_MARKED_FOR_DELETE) {
+		return fmt.Errorf("failed to delete service %s: %w", serviceName, ErrServiceDeletePending)
+	}
 	if err != nil {
 		return fmt.Errorf("failed to open service %s: %w", serviceName, err)
 	}
-	defer windows.CloseServiceHandle(svc)
+
+	// Stop the services depending on it first, in the order the SCM lists them
+	dependents, err := s.ListDependentServices(svc.Active)
+	if err != nil {
+		s.Close()
+		return fmt.Errorf("failed to list services depending on %s: %w", serviceName, err)
+	}
+	for _, name := range dependents {
+		if err := stopServiceByName(m, name); err != nil {
+			s.Close()
+			return err
+		}
+	}
+	if err := stopService(s); err != nil {
+		s.Close()
+		return err
+	}
 
 	// Attempt to delete the service
-	if err := windows.DeleteService(svc); err != nil {
+	err = s.Delete()
+	s.Close()
+	if errors.Is(err, windows.ERROR_SERVICE_MARKED_FOR_DELETE) {
+		return fmt.Errorf("failed to delete service %s: %w", serviceName, ErrServiceDeletePending)
+	}
+	if err != nil {
 		return fmt.Errorf("failed to delete service %s: %w", serviceName, err)
 	}
 
+	// Confirm the SCM entry is gone
+	if err := waitServiceDeleted(m, serviceName, serviceStopTimeout); err != nil {
+		return err
+	}
+
 	log.Printf("Service %s deleted successfully", serviceName)
 	return nil
 }
 
-// CreateServiceBatchFile creates a batch file that runs the specified command with arguments.
-func CreateServiceBatchFile(command, args, filePath string) error {
-	// Open or create the batch file
-	file, err := os.Create(filePath)
+// stopServiceByName stops the service name.
+func stopServiceByName(m *mgr.Mgr, name string) error {
+	s, err := m.OpenService(name)
 	if err != nil {
-		return fmt.Errorf("failed to create batch file: %w", err)
+		return fmt.Errorf("failed to open service %s: %w", name, err)
 	}
-	defer file.Close()
-
-	// Combine the command and arguments into one string
-	fullCommand := fmt.Sprintf("%s %s", command, args)
+	defer s.Close()
+	return stopService(s)
+}
 
-	// Write the command to the batch file
-	_, err = file.WriteString(fullCommand)
+// stopService stops the service s, if it is running, and waits until it has
+// stopped.
+func stopService(s *mgr.Service) error {
+	status, err := s.Query()
 	if err != nil {
-		return fmt.Errorf("failed to write to batch file: %w", err)
+		return fmt.Errorf("failed to query service %s: %w", s.Name, err)
 	}
-
-	fmt.Printf("Batch file created successfully at: %s\n", filePath)
-	return nil
-}
-
-const (
-	errnoERROR_IO_PENDING = 997
-)
-
-var (
-	errERROR_IO_PENDING error = syscall.Errno(errnoERROR_IO_PENDING)
-)
-
-// errnoErr returns common boxed Errno values, to prevent
-// allocations at runtime.
-func errnoErr(e syscall.Errno) error {
-	switch e {
-	case 0:
+	if status.State == svc.Stopped {
 		return nil
-	case errnoERROR_IO_PENDING:
-		return errERROR_IO_PENDING
 	}
-	// TODO: add more here, after collecting data on the common
-	// error values see on Windows. (perhaps when running
-	// all.bat?)
-	return e
-}
-
-var (
-	modadvapi32 = windows.NewLazySystemDLL("advapi32.dll")
-	modkernel32 = windows.NewLazySystemDLL("kernel32.dll")
 
-	procGetCurrentThread = modkernel32.NewProc("GetCurrentThread")
-	procOpenThreadToken  = modadvapi32.NewProc("OpenThreadToken")
-	procImpersonateSelf  = modadvapi32.NewProc("ImpersonateSelf")
-	procRevertToSelf     = modadvapi32.NewProc("RevertToSelf")
-)
-
-func GetCurrentThread() (pseudoHandle windows.Handle, err error) {
-	r0, _, e1 := syscall.Syscall(procGetCurrentThread.Addr(), 0, 0, 0, 0)
-	pseudoHandle = windows.Handle(r0)
-	if pseudoHandle == 0 {
-		if e1 != 0 {
-			err = errnoErr(e1)
-		} else {
-			err = syscall.EINVAL
+	if status.State != svc.StopPending {
+		_, err = s.Control(svc.Stop)
+		if err != nil && !errors.Is(err, windows.ERROR_SERVICE_NOT_ACTIVE) {
+			return fmt.Errorf("failed to stop service %s: %w", s.Name, err)
 		}
 	}
-	return
-}
 
-func OpenThreadToken(h windows.Handle, access uint32, self bool, token *windows.Token) (err error) {
-	var _p0 uint32
-	if self {
-		_p0 = 1
-	} else {
-		_p0 = 0
-	}
-	r1, _, e1 := syscall.Syscall6(procOpenThreadToken.Addr(), 4, uintptr(h), uintptr(access), uintptr(_p0), uintptr(unsafe.Pointer(token)), 0, 0)
-	if r1 == 0 {
-		if e1 != 0 {
-			err = errnoErr(e1)
-		} else {
-			err = syscall.EINVAL
-		}
+	if err := waitServiceState(s, svc.Stopped, serviceStopTimeout); err != nil {
+		return err
 	}
-	return
+	fmt.Printf("Service %s stopped.\n", s.Name)
+	return nil
 }
 
-func ImpersonateSelf() (err error) {
-	r0, _, e1 := syscall.Syscall(procImpersonateSelf.Addr(), 1, uintptr(2), 0, 0)
-	if r0 == 0 {
-		if e1 != 0 {
-			err = errnoErr(e1)
-		} else {
-			err = syscall.EINVAL
+// waitServiceDeleted waits until the SCM no longer knows the service name.
+// It returns ErrServiceDeletePending if the service is still registered
+// after timeout.
+func waitServiceDeleted(m *mgr.Mgr, name string, timeout time.Duration) error {
+	deadline := time.After(timeout)
+	ticker := time.NewTicker(servicePollInterval)
+	defer ticker.Stop()
+	for {
+		s, err := m.OpenService(name)
+		if errors.Is(err, windows.ERROR_SERVICE_DOES_NOT_EXIST) {
+			return nil
+		}
+		// A service marked for deletion is still registered
+		if err != nil && !errors.Is(err, windows.ERROR_SERVICE_MARKED_FOR_DELETE) {
+			return fmt.Errorf("failed to open service %s: %w", name, err)
+		}
+		if err == nil {
+			s.Close()
 		}
-	}
-	return
-}
 
-func RevertToSelf() (err error) {
-	r0, _, e1 := syscall.Syscall(procRevertToSelf.Addr(), 0, 0, 0, 0)
-	if r0 == 0 {
-		if e1 != 0 {
-			err = errnoErr(e1)
-		} else {
-			err = syscall.EINVAL
+		select {
+		case <-deadline:
+			return fmt.Errorf("service %s is still registered: %w; close programs such as the Services console that hold it open, or reboot", name, ErrServiceDeletePending)
+		case <-ticker.C:
 		}
 	}
-	return
 }
 
-func OpenCurrentThreadToken() (windows.Token, error) {
-	if e := ImpersonateSelf(); e != nil {
-		return 0, e
+// CreateServiceBatchFile creates a batch file that runs the specified command with arguments.
+func CreateServiceBatchFile(command string, args []string, filePath string) error {
+	// Combine the command and arguments into one line escaped for cmd.exe
+	fullCommand, err := BuildBatchCommandLine(command, args)
+	if err != nil {
+		return fmt.Errorf("invalid command: %w", err)
 	}
-	defer RevertToSelf()
-	t, e := GetCurrentThread()
-	if e != nil {
-		return 0, e
+
+	// Open or create the batch file
+	file, err := os.Create(filePath)
+	if err != nil {
+		return fmt.Errorf("failed to create batch file: %w", err)
 	}
-	var tok windows.Token
-	e = OpenThreadToken(t, windows.TOKEN_QUERY, true, &tok)
-	if e != nil {
-		return 0, e
+	defer file.Close()
+
+	// Write the command to the batch file
+	_, err = file.WriteString(fullCommand)
+	if err != nil {
+		return fmt.Errorf("failed to write to batch file: %w", err)
 	}
-	return tok, nil
+
+	fmt.Printf("Batch file created successfully at: %s\n", filePath)
+	return nil
 }
@@ -0,0 +1,177 @@
+//go:build windows
+
+package persist
+
+import (
+	"fmt"
+	"golang.org/x/sys/windows/svc/mgr"
+	"strings"
+)
+
+// serviceStartTypes maps start type names to SCM start types and whether
+// the start is delayed.
+var serviceStartTypes = map[string]struct {
+	startType uint32
+	delayed   bool
+}{
+	"auto":         {mgr.StartAutomatic, false},
+	"delayed-auto": {mgr.StartAutomatic, true},
+	"demand":       {mgr.StartManual, false},
+	"manual":       {mgr.StartManual, false},
+	"disabled":     {mgr.StartDisabled, false},
+}
+
+// serviceErrorControls maps error control names to SCM error controls.
+var serviceErrorControls = map[string]uint32{
+	"ignore":   mgr.ErrorIgnore,
+	"normal":   mgr.ErrorNormal,
+	"severe":   mgr.ErrorSevere,
+	"critical": mgr.ErrorCritical,
+}
+
+// serviceAccounts maps the names of the built-in service accounts to the
+// account names the SCM expects.
+var serviceAccounts = map[string]string{
+	"localsystem":                  "LocalSystem",
+	"system":                       "LocalSystem",
+	"nt authority\\system":         "LocalSystem",
+	"localservice":                 `NT AUTHORITY\LocalService`,
+	"nt authority\\localservice":   `NT AUTHORITY\LocalService`,
+	"networkservice":               `NT AUTHORITY\NetworkService`,
+	"nt authority\\networkservice": `NT AUTHORITY\NetworkService`,
+}
+
+// ServiceOptions are the optional settings of a service created by
+// CreateService. Without options the service starts automatically, runs as
+// LocalSystem in its own process and has normal error control.
+type ServiceOptions struct {
+	DisplayName      string
+	Description      string
+	StartType        uint32 // mgr.StartAutomatic, mgr.StartManual or mgr.StartDisabled
+	DelayedAutoStart bool
+	Account          string // account the service runs as, LocalSystem if empty
+	Password         string // password of Account, for user accounts only
+	Dependencies     []string
+	LoadOrderGroup   string
+	ErrorControl     uint32 // mgr.ErrorIgnore, mgr.ErrorNormal, ...
+	Recovery         *ServiceRecovery
+	Triggers         []ServiceTrigger // events that start or stop the service
+}
+
+// NewServiceOptions builds service options from their command line form:
+//
+//   - startType: auto (default), delayed-auto, demand or disabled
+//   - account: LocalSystem (default), LocalService, NetworkService, virtual
+//     for the virtual account NT SERVICE\<service name>, or DOMAIN\user
+//   - dependencies: comma separated service names; groups are prefixed with +
+//   - errorControl: ignore, normal (default), severe or critical
+func NewServiceOptions(serviceName, displayName, description, startType, account, password, dependencies, group, errorControl string) (*ServiceOptions, error) {
+	opts := &ServiceOptions{
+		DisplayName:    displayName,
+		Description:    description,
+		Password:       password,
+		LoadOrderGroup: group,
+		StartType:      mgr.StartAutomatic,
+		ErrorControl:   mgr.ErrorNormal,
+	}
+
+	if startType != "" {
+		st, ok := serviceStartTypes[strings.ToLower(startType)]
+		if !ok {
+			return nil, fmt.Errorf("unknown start type %q, expected auto, delayed-auto, demand or disabled", startType)
+		}
+		opts.StartType, opts.DelayedAutoStart = st.startType, st.delayed
+	}
+
+	if errorControl != "" {
+		ec, ok := serviceErrorControls[strings.ToLower(errorControl)]
+		if !ok {
+			return nil, fmt.Errorf("unknown error control %q, expected ignore, normal, severe or critical", errorControl)
+		}
+		opts.ErrorControl = ec
+	}
+
+	for _, dep := range strings.Split(dependencies, ",") {
+		if dep = strings.TrimSpace(dep); dep != "" {
+			opts.Dependencies = append(opts.Dependencies, dep)
+		}
+	}
+
+	var err error
+	opts.Account, err = serviceAccount(serviceName, account)
+	if err != nil {
+		return nil, err
+	}
+	if err := opts.checkPassword(); err != nil {
+		return nil, err
+	}
+	return opts, nil
+}
+
+// serviceAccount returns the SCM name of the account a service runs as.
+func serviceAccount(serviceName, account string) (string, error) {
+	if account == "" {
+		return "", nil
+	}
+	if name, ok := serviceAccounts[strings.ToLower(account)]; ok {
+		return name, nil
+	}
+	if strings.EqualFold(account, "virtual") {
+		return `NT SERVICE\` + serviceName, nil
+	}
+	if !strings.Contains(account, `\`) && !strings.Contains(account, "@") {
+		return "", fmt.Errorf("account %q must be LocalSystem, LocalService, NetworkService, virtual, DOMAIN\\user, .\\user or user@domain", account)
+	}
+	return account, nil
+}
+
+// checkPassword checks a password is given for user accounts only. Built-in,
+// virtual and managed service accounts (ending in $) have no password.
+func (o *ServiceOptions) checkPassword() error {
+	if o.isUserAccount() {
+		if o.Password == "" {
+			return fmt.Errorf("account %s requires a password", o.Account)
+		}
+		return nil
+	}
+	if o.Password != "" {
+		return fmt.Errorf("account %s does not take a password", o.accountName())
+	}
+	return nil
+}
+
+// isUserAccount reports whether the service runs as a user account that
+// logs on with a password.
+func (o *ServiceOptions) isUserAccount() bool {
+	if o.Account == "" || o.Account == "LocalSystem" {
+		return false
+	}
+	upper := strings.ToUpper(o.Account)
+	return !strings.HasPrefix(upper, `NT AUTHORITY\`) && !strings.HasPrefix(upper, `NT SERVICE\`) && !strings.HasSuffix(o.Account, "$")
+}
+
+// accountName returns the account the service runs as for messages.
+func (o *ServiceOptions) accountName() string {
+	if o.Account == "" {
+		return "LocalSystem"
+	}
+	return o.Account
+}
+
+// config returns the SCM configuration for the options.
+func (o *ServiceOptions) config() mgr.Config {
+	if o == nil {
+		o = &ServiceOptions{StartType: mgr.StartAutomatic, ErrorControl: mgr.ErrorNormal}
+	}
+	return mgr.Config{
+		DisplayName:      o.DisplayName,
+		Description:      o.Description,
+		StartType:        o.StartType,
+		DelayedAutoStart: o.DelayedAutoStart,
+		ErrorControl:     o.ErrorControl,
+		ServiceStartName: o.Account,
+		Password:         o.Password,
+		Dependencies:     o.Dependencies,
+		LoadOrderGroup:   o.LoadOrderGroup,
+	}
+}
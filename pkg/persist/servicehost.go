@@ -0,0 +1,202 @@
+//go:build windows
+
+package persist
+
+import (
+	"errors"
+	"fmt"
+	"golang.org/x/sys/windows"
+	"golang.org/x/sys/windows/svc"
+	"os/exec"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+const (
+	// serviceHostStopGrace is how long the service host gives the command to
+	// exit after sending it a Ctrl-Break on stop or shutdown.
+	serviceHostStopGrace = 5 * time.Second
+	// serviceHostStopTimeout is how long the service host waits for the
+	// command to exit after terminating it.
+	serviceHostStopTimeout = 10 * time.Second
+)
+
+// procAllocConsole is AllocConsole, which x/sys/windows does not wrap.
+var procAllocConsole = windows.NewLazySystemDLL("kernel32.dll").NewProc("AllocConsole")
+
+// RunServiceHost runs command with args as the service serviceName. It is
+// the entry point of GoPersist when the SCM starts it as the binary of a
+// hosted service: it reports the service running, starts the command and
+// stops it, together with any processes it started, when the service is
+// stopped or the system shuts down. If the command exits by itself, the
+// service stops with the command's exit code.
+func RunServiceHost(serviceName, command string, args []string) error {
+	isService, err := svc.IsWindowsService()
+	if err != nil {
+		return fmt.Errorf("failed to determine if running as a service: %w", err)
+	}
+	if !isService {
+		return fmt.Errorf("the service host must be started by the service control manager")
+	}
+
+	err = svc.Run(serviceName, &serviceHost{command: command, args: args})
+	if err != nil {
+		return fmt.Errorf("failed to run service %s: %w", serviceName, err)
+	}
+	return nil
+}
+
+// serviceHost is the svc.Handler of a hosted command.
+type serviceHost struct {
+	command string
+	args    []string
+}
+
+// Execute starts the command and serves control requests until the command
+// exits or the service is stopped.
+func (h *serviceHost) Execute(_ []string, requests <-chan svc.ChangeRequest, status chan<- svc.Status) (bool, uint32) {
+	status <- svc.Status{State: svc.StartPending}
+
+	// Put the command in a job, so that stopping the service also ends the
+	// processes it starts
+	job, err := newKillOnCloseJob()
+	if err != nil {
+		return serviceExitCode(err)
+	}
+	defer windows.CloseHandle(job)
+
+	// Give the command a console shared with the service host, so that it can
+	// be sent a Ctrl-Break. Fails harmlessly if the host already has one
+	procAllocConsole.Call()
+
+	// Start the command suspended, so that it cannot start processes outside
+	// the job before it is assigned to it, and in its own process group so
+	// that a Ctrl-Break reaches only the command
+	cmd := exec.Command(h.command, h.args...)
+	cmd.SysProcAttr = &syscall.SysProcAttr{CreationFlags: windows.CREATE_SUSPENDED | windows.CREATE_NEW_PROCESS_GROUP}
+	if err := cmd.Start(); err != nil {
+		return serviceExitCode(err)
+	}
+	if err := assignToJob(job, cmd.Process.Pid); err != nil {
+		cmd.Process.Kill()
+		return serviceExitCode(err)
+	}
+	if err := resumeProcess(cmd.Process.Pid); err != nil {
+		cmd.Process.Kill()
+		return serviceExitCode(err)
+	}
+
+	exited := make(chan error, 1)
+	go func() { exited <- cmd.Wait() }()
+
+	status <- svc.Status{State: svc.Running, Accepts: svc.AcceptStop | svc.AcceptShutdown}
+	for {
+		select {
+		case err := <-exited:
+			// The command ended by itself, stop the service with its exit code
+			return serviceExitCode(err)
+		case req := <-requests:
+			switch req.Cmd {
+			case svc.Interrogate:
+				status <- req.CurrentStatus
+			case svc.Stop, svc.Shutdown:
+				status <- svc.Status{State: svc.StopPending, WaitHint: uint32((serviceHostStopGrace + serviceHostStopTimeout) / time.Millisecond)}
+
+				// Ask the command to exit, and only terminate the job if it
+				// does not in time
+				windows.GenerateConsoleCtrlEvent(windows.CTRL_BREAK_EVENT, uint32(cmd.Process.Pid))
+				select {
+				case <-exited:
+					return false, 0
+				case <-time.After(serviceHostStopGrace):
+				}
+				windows.TerminateJobObject(job, 0)
+				select {
+				case <-exited:
+				case <-time.After(serviceHostStopTimeout):
+				}
+				return false, 0
+			}
+		}
+	}
+}
+
+// serviceExitCode converts the error starting or running the command into
+// the exit code of the service: a Win32 error code, or the exit code of the
+// command as a service specific code.
+func serviceExitCode(err error) (bool, uint32) {
+	if err == nil {
+		return false, 0
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return true, uint32(exitErr.ExitCode())
+	}
+	var errno syscall.Errno
+	if errors.As(err, &errno) {
+		return false, uint32(errno)
+	}
+	return true, 1
+}
+
+// newKillOnCloseJob creates a job object that terminates its processes when
+// its last handle is closed.
+func newKillOnCloseJob() (windows.Handle, error) {
+	job, err := windows.CreateJobObject(nil, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create job object: %w", err)
+	}
+
+	info := windows.JOBOBJECT_EXTENDED_LIMIT_INFORMATION{}
+	info.BasicLimitInformation.LimitFlags = windows.JOB_OBJECT_LIMIT_KILL_ON_JOB_CLOSE
+	_, err = windows.SetInformationJobObject(job, windows.JobObjectExtendedLimitInformation, uintptr(unsafe.Pointer(&info)), uint32(unsafe.Sizeof(info)))
+	if err != nil {
+		windows.CloseHandle(job)
+		return 0, fmt.Errorf("failed to configure job object: %w", err)
+	}
+	return job, nil
+}
+
+// assignToJob adds the process pid to job.
+func assignToJob(job windows.Handle, pid int) error {
+	process, err := windows.OpenProcess(windows.PROCESS_SET_QUOTA|windows.PROCESS_TERMINATE, false, uint32(pid))
+	if err != nil {
+		return fmt.Errorf("failed to open process %d: %w", pid, err)
+	}
+	defer windows.CloseHandle(process)
+
+	if err := windows.AssignProcessToJobObject(job, process); err != nil {
+		return fmt.Errorf("failed to assign process %d to job: %w", pid, err)
+	}
+	return nil
+}
+
+// resumeProcess resumes the threads of the suspended process pid.
+func resumeProcess(pid int) error {
+	snapshot, err := windows.CreateToolhelp32Snapshot(windows.TH32CS_SNAPTHREAD, 0)
+	if err != nil {
+		return fmt.Errorf("failed to list threads: %w", err)
+	}
+	defer windows.CloseHandle(snapshot)
+
+	entry := windows.ThreadEntry32{Size: uint32(unsafe.Sizeof(windows.ThreadEntry32{}))}
+	for err = windows.Thread32First(snapshot, &entry); err == nil; err = windows.Thread32Next(snapshot, &entry) {
+		if entry.OwnerProcessID != uint32(pid) {
+			continue
+		}
+		thread, openErr := windows.OpenThread(windows.THREAD_SUSPEND_RESUME, false, entry.ThreadID)
+		if openErr != nil {
+			return fmt.Errorf("failed to open thread %d of process %d: %w", entry.ThreadID, pid, openErr)
+		}
+		_, resumeErr := windows.ResumeThread(thread)
+		windows.CloseHandle(thread)
+		if resumeErr != nil {
+			return fmt.Errorf("failed to resume thread %d of process %d: %w", entry.ThreadID, pid, resumeErr)
+		}
+	}
+	if !errors.Is(err, windows.ERROR_NO_MORE_FILES) {
+		return fmt.Errorf("failed to list threads: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,245 @@
+//go:build windows
+
+package persist
+
+import (
+	"fmt"
+	"golang.org/x/sys/windows"
+	"golang.org/x/sys/windows/svc/mgr"
+	"strings"
+	"unsafe"
+)
+
+// ServiceChanges are the changes ModifyService makes to an existing service.
+// Empty fields are left unchanged.
+type ServiceChanges struct {
+	ExecutablePath string
+	Args           []string
+	DisplayName    string
+	Description    string
+	StartType      string // auto, delayed-auto, demand or disabled
+	Account        string // as for NewServiceOptions
+	Password       string
+}
+
+// ModifyService changes the configuration of the existing service
+// serviceName. The configuration from before the first modification is
+// recorded in the state file, so that RestoreService can put it back. The
+// changes take effect when the service is next started.
+func ModifyService(serviceName string, changes ServiceChanges) error {
+	// Fail early unless elevated
+	if err := checkRequirement("modify service "+serviceName, serviceRequirement); err != nil {
+		return err
+	}
+
+	// Open a handle to the Service Control Manager
+	m, err := mgr.Connect()
+	if err != nil {
+		return fmt.Errorf("failed to connect to service control manager: %w", err)
+	}
+	defer m.Disconnect()
+
+	// Open a handle to the service
+	s, err := m.OpenService(serviceName)
+	if err != nil {
+		return fmt.Errorf("failed to open service %s: %w", serviceName, err)
+	}
+	defer s.Close()
+
+	current, err := s.Config()
+	if err != nil {
+		return fmt.Errorf("failed to read configuration of service %s: %w", serviceName, err)
+	}
+
+	// Work out the changes before touching anything
+	startType, delayed := uint32(windows.SERVICE_NO_CHANGE), current.DelayedAutoStart
+	if changes.StartType != "" {
+		st, ok := serviceStartTypes[strings.ToLower(changes.StartType)]
+		if !ok {
+			return fmt.Errorf("unknown start type %q, expected auto, delayed-auto, demand or disabled", changes.StartType)
+		}
+		startType, delayed = st.startType, st.delayed
+	}
+
+	var account, password *uint16
+	if changes.Account != "" {
+		if (&ServiceOptions{Account: current.ServiceStartName}).isUserAccount() {
+			return fmt.Errorf("service %s runs as %s, whose password GoPersist cannot restore; its account cannot be changed", serviceName, current.ServiceStartName)
+		}
+		opts := &ServiceOptions{Password: changes.Password}
+		if opts.Account, err = serviceAccount(serviceName, changes.Account); err != nil {
+			return err
+		}
+		if err := opts.checkPassword(); err != nil {
+			return err
+		}
+		account, password = serviceStringPtr(opts.accountName()), serviceStringPtr(opts.Password)
+	}
+
+	var binaryPath, displayName *uint16
+	if changes.ExecutablePath != "" {
+		cmdline, err := BuildCommandLine(changes.ExecutablePath, changes.Args)
+		if err != nil {
+			return fmt.Errorf("invalid service binary: %w", err)
+		}
+		binaryPath = serviceStringPtr(cmdline)
+	}
+	if changes.DisplayName != "" {
+		displayName = serviceStringPtr(changes.DisplayName)
+	}
+
+	// Record the original configuration so that remove can restore it
+	st, err := loadState()
+	if err != nil {
+		return err
+	}
+	st.recordServiceSnapshot(snapshotFromConfig(serviceName, current))
+	if err := st.save(); err != nil {
+		return err
+	}
+
+	// Apply the changes
+	err = windows.ChangeServiceConfig(s.Handle, windows.SERVICE_NO_CHANGE, startType, windows.SERVICE_NO_CHANGE,
+		binaryPath, nil, nil, nil, account, password, displayName)
+	if err != nil {
+		return fmt.Errorf("failed to change configuration of service %s: %w", serviceName, err)
+	}
+	if changes.StartType != "" {
+		if err := setServiceDelayedAutoStart(s.Handle, delayed); err != nil {
+			return err
+		}
+	}
+	if changes.Description != "" {
+		if err := setServiceDescription(s.Handle, changes.Description); err != nil {
+			return err
+		}
+	}
+
+	fmt.Printf("Service %s modified, the changes take effect when it is next started.\n", serviceName)
+	return nil
+}
+
+// RestoreService puts back the configuration the service serviceName had
+// before ModifyService first changed it. restored is false if GoPersist did
+// not modify the service.
+func RestoreService(serviceName string) (restored bool, err error) {
+	st, err := loadState()
+	if err != nil {
+		return false, err
+	}
+	snapshot := st.serviceSnapshot(serviceName)
+	if snapshot == nil {
+		return false, nil
+	}
+	if err := checkRequirement("restore service "+serviceName, serviceRequirement); err != nil {
+		return false, err
+	}
+
+	// Open a handle to the Service Control Manager
+	m, err := mgr.Connect()
+	if err != nil {
+		return false, fmt.Errorf("failed to connect to service control manager: %w", err)
+	}
+	defer m.Disconnect()
+
+	// Open a handle to the service
+	s, err := m.OpenService(serviceName)
+	if err != nil {
+		return false, fmt.Errorf("failed to open service %s: %w", serviceName, err)
+	}
+	defer s.Close()
+
+	current, err := s.Config()
+	if err != nil {
+		return false, fmt.Errorf("failed to read configuration of service %s: %w", serviceName, err)
+	}
+
+	// Only pass the account if it changed, built-in accounts take an empty
+	// password
+	var account, password *uint16
+	if !strings.EqualFold(current.ServiceStartName, snapshot.ServiceStartName) {
+		account, password = serviceStringPtr(snapshot.ServiceStartName), serviceStringPtr("")
+	}
+
+	// Empty strings, unlike nil, clear the group and dependencies
+	err = windows.ChangeServiceConfig(s.Handle, snapshot.ServiceType, snapshot.StartType, snapshot.ErrorControl,
+		serviceStringPtr(snapshot.BinaryPathName), serviceStringPtr(snapshot.LoadOrderGroup), nil,
+		serviceMultiStringPtr(snapshot.Dependencies), account, password, serviceStringPtr(snapshot.DisplayName))
+	if err != nil {
+		return false, fmt.Errorf("failed to restore configuration of service %s: %w", serviceName, err)
+	}
+	if err := setServiceDelayedAutoStart(s.Handle, snapshot.DelayedAutoStart); err != nil {
+		return false, err
+	}
+	if err := setServiceDescription(s.Handle, snapshot.Description); err != nil {
+		return false, err
+	}
+
+	st.forgetServiceSnapshot(serviceName)
+	if err := st.save(); err != nil {
+		return false, err
+	}
+
+	fmt.Printf("Service %s restored to its original configuration.\n", serviceName)
+	return true, nil
+}
+
+// snapshotFromConfig records the configuration of the service name.
+func snapshotFromConfig(name string, c mgr.Config) serviceSnapshot {
+	return serviceSnapshot{
+		Name:             name,
+		ServiceType:      c.ServiceType,
+		StartType:        c.StartType,
+		DelayedAutoStart: c.DelayedAutoStart,
+		ErrorControl:     c.ErrorControl,
+		BinaryPathName:   c.BinaryPathName,
+		LoadOrderGroup:   c.LoadOrderGroup,
+		Dependencies:     c.Dependencies,
+		ServiceStartName: c.ServiceStartName,
+		DisplayName:      c.DisplayName,
+		Description:      c.Description,
+	}
+}
+
+// setServiceDescription sets the description of the service handle; an
+// empty description removes it.
+func setServiceDescription(service windows.Handle, description string) error {
+	d := windows.SERVICE_DESCRIPTION{Description: serviceStringPtr(description)}
+	if err := windows.ChangeServiceConfig2(service, windows.SERVICE_CONFIG_DESCRIPTION, (*byte)(unsafe.Pointer(&d))); err != nil {
+		return fmt.Errorf("failed to set service description: %w", err)
+	}
+	return nil
+}
+
+// setServiceDelayedAutoStart sets whether the automatic start of the service
+// handle is delayed.
+func setServiceDelayedAutoStart(service windows.Handle, delayed bool) error {
+	var info windows.SERVICE_DELAYED_AUTO_START_INFO
+	if delayed {
+		info.IsDelayedAutoStartUp = 1
+	}
+	if err := windows.ChangeServiceConfig2(service, windows.SERVICE_CONFIG_DELAYED_AUTO_START_INFO, (*byte)(unsafe.Pointer(&info))); err != nil {
+		return fmt.Errorf("failed to set delayed auto start: %w", err)
+	}
+	return nil
+}
+
+// serviceStringPtr converts s to a UTF-16 string for the SCM, cut off at the
+// first NUL. Unlike a nil pointer, which leaves a setting unchanged, an
+// empty string clears it.
+func serviceStringPtr(s string) *uint16 {
+	s, _, _ = strings.Cut(s, "\x00")
+	p, _ := windows.UTF16PtrFromString(s)
+	return p
+}
+
+// serviceMultiStringPtr converts values to a UTF-16 multi-string for the
+// SCM. An empty list gives an empty multi-string, which clears the setting.
+func serviceMultiStringPtr(values []string) *uint16 {
+	var block []uint16
+	for _, value := range values {
+		block = append(block, windows.StringToUTF16(value)...)
+	}
+	block = append(block, 0)
+	return &block[0]
+}
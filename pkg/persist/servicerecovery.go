@@ -0,0 +1,211 @@
+//go:build windows
+
+package persist
+
+import (
+	"fmt"
+	"golang.org/x/sys/windows/svc/mgr"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// serviceRecoveryActions maps recovery action names to SCM action types.
+var serviceRecoveryActions = map[string]int{
+	"none":    mgr.NoAction,
+	"restart": mgr.ServiceRestart,
+	"reboot":  mgr.ComputerReboot,
+	"run":     mgr.RunCommand,
+}
+
+// ServiceRecovery is what the SCM does when a service fails. The n-th
+// action is taken on the n-th failure, the last one for any further
+// failures, until the failure count is reset.
+type ServiceRecovery struct {
+	Actions          []mgr.RecoveryAction
+	ResetPeriod      time.Duration // time without failures after which the failure count is reset
+	Command          string        // command line of run actions
+	RebootMessage    string        // message broadcast before reboot actions
+	NonCrashFailures bool          // also act when the service stops with a non-zero exit code
+}
+
+// ParseServiceRecovery parses a recovery spec of key=value pairs, values
+// quoted with ' or " if they contain white space. The action keys restart,
+// reboot, run and none take the delay before the action and may be
+// repeated; they are taken in order on the first, second, ... failure:
+//
+//	restart=5s restart=1m run=0s reset=1d command="C:\Tools\alert.exe --service x" non-crash=true
+func ParseServiceRecovery(spec string) (*ServiceRecovery, error) {
+	fields, err := splitSpecFields(spec)
+	if err != nil {
+		return nil, err
+	}
+
+	recovery := &ServiceRecovery{}
+	for _, field := range fields {
+		key, value, ok := strings.Cut(field, "=")
+		if !ok {
+			return nil, fmt.Errorf("recovery setting %q is not in key=value form", field)
+		}
+		key = strings.ToLower(key)
+
+		if actionType, ok := serviceRecoveryActions[key]; ok {
+			delay, err := parseServiceDuration(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s delay: %w", key, err)
+			}
+			recovery.Actions = append(recovery.Actions, mgr.RecoveryAction{Type: actionType, Delay: delay})
+			continue
+		}
+
+		switch key {
+		case "reset":
+			recovery.ResetPeriod, err = parseServiceDuration(value)
+		case "command":
+			recovery.Command = value
+		case "reboot-message":
+			recovery.RebootMessage = value
+		case "non-crash":
+			var flag *bool
+			flag, err = boolSetting(value)
+			if err == nil {
+				recovery.NonCrashFailures = *flag
+			}
+		default:
+			err = fmt.Errorf("unknown recovery setting")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid recovery setting %s: %w", key, err)
+		}
+	}
+
+	if len(recovery.Actions) == 0 {
+		return nil, fmt.Errorf("recovery requires at least one restart, reboot, run or none action")
+	}
+	for _, action := range recovery.Actions {
+		if action.Type == mgr.RunCommand && recovery.Command == "" {
+			return nil, fmt.Errorf("run recovery actions require command")
+		}
+	}
+	return recovery, nil
+}
+
+// parseServiceDuration parses a duration such as 30s or 5m, also accepting
+// whole days such as 1d.
+func parseServiceDuration(value string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(value, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("expected a duration such as 30s, 5m or 1d, got %q", value)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("expected a duration such as 30s, 5m or 1d, got %q", value)
+	}
+	return d, nil
+}
+
+// apply configures the recovery of the service s.
+func (r *ServiceRecovery) apply(s *mgr.Service) error {
+	// The command and reboot message must be set before actions using them
+	if r.Command != "" {
+		if err := s.SetRecoveryCommand(r.Command); err != nil {
+			return fmt.Errorf("failed to set recovery command: %w", err)
+		}
+	}
+	if r.RebootMessage != "" {
+		if err := s.SetRebootMessage(r.RebootMessage); err != nil {
+			return fmt.Errorf("failed to set reboot message: %w", err)
+		}
+	}
+	// The SCM only accepts reboot actions from a caller with
+	// SeShutdownPrivilege enabled
+	if r.reboots() {
+		if err := enablePrivilege(shutdownPrivilege); err != nil {
+			return err
+		}
+	}
+	if err := s.SetRecoveryActions(r.Actions, uint32(r.ResetPeriod/time.Second)); err != nil {
+		return fmt.Errorf("failed to set recovery actions: %w", err)
+	}
+	if err := s.SetRecoveryActionsOnNonCrashFailures(r.NonCrashFailures); err != nil {
+		return fmt.Errorf("failed to set recovery on non-crash failures: %w", err)
+	}
+	return nil
+}
+
+// reboots reports whether any of the actions reboots the computer.
+func (r *ServiceRecovery) reboots() bool {
+	for _, a := range r.Actions {
+		if a.Type == mgr.ComputerReboot {
+			return true
+		}
+	}
+	return false
+}
+
+// requirement returns what applying the recovery needs: reboot actions
+// need SeShutdownPrivilege on top of managing services.
+func (r *ServiceRecovery) requirement() Requirement {
+	if !r.reboots() {
+		return serviceRequirement
+	}
+	return Requirement{
+		Elevated:   serviceRequirement.Elevated,
+		Privileges: append(append([]string(nil), serviceRequirement.Privileges...), shutdownPrivilege),
+	}
+}
+
+// readServiceRecovery reads the recovery configuration of the service s. It
+// returns nil if the service has no recovery actions.
+func readServiceRecovery(s *mgr.Service) (*ServiceRecovery, error) {
+	actions, err := s.RecoveryActions()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read recovery actions: %w", err)
+	}
+	if len(actions) == 0 {
+		return nil, nil
+	}
+
+	recovery := &ServiceRecovery{Actions: actions}
+	resetPeriod, err := s.ResetPeriod()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read recovery reset period: %w", err)
+	}
+	recovery.ResetPeriod = time.Duration(resetPeriod) * time.Second
+
+	if recovery.Command, err = s.RecoveryCommand(); err != nil {
+		return nil, fmt.Errorf("failed to read recovery command: %w", err)
+	}
+	if recovery.RebootMessage, err = s.RebootMessage(); err != nil {
+		return nil, fmt.Errorf("failed to read reboot message: %w", err)
+	}
+	if recovery.NonCrashFailures, err = s.RecoveryActionsOnNonCrashFailures(); err != nil {
+		return nil, fmt.Errorf("failed to read recovery on non-crash failures: %w", err)
+	}
+	return recovery, nil
+}
+
+// String formats the recovery for display in the form ParseServiceRecovery
+// accepts.
+func (r *ServiceRecovery) String() string {
+	var parts []string
+	for _, action := range r.Actions {
+		for name, actionType := range serviceRecoveryActions {
+			if actionType == action.Type {
+				parts = append(parts, fmt.Sprintf("%s=%s", name, action.Delay))
+			}
+		}
+	}
+	parts = append(parts, fmt.Sprintf("reset=%s", r.ResetPeriod))
+	if r.Command != "" {
+		parts = append(parts, fmt.Sprintf("command='%s'", r.Command))
+	}
+	if r.RebootMessage != "" {
+		parts = append(parts, fmt.Sprintf("reboot-message='%s'", r.RebootMessage))
+	}
+	parts = append(parts, fmt.Sprintf("non-crash=%t", r.NonCrashFailures))
+	return strings.Join(parts, " ")
+}
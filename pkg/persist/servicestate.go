@@ -0,0 +1,50 @@
+package persist
+
+import "strings"
+
+// serviceSnapshot is the configuration of a service before GoPersist first
+// modified it.
+type serviceSnapshot struct {
+	Name             string   `json:"name"`
+	ServiceType      uint32   `json:"serviceType"`
+	StartType        uint32   `json:"startType"`
+	DelayedAutoStart bool     `json:"delayedAutoStart,omitempty"`
+	ErrorControl     uint32   `json:"errorControl"`
+	BinaryPathName   string   `json:"binaryPathName"`
+	LoadOrderGroup   string   `json:"loadOrderGroup,omitempty"`
+	Dependencies     []string `json:"dependencies,omitempty"`
+	ServiceStartName string   `json:"serviceStartName,omitempty"`
+	DisplayName      string   `json:"displayName"`
+	Description      string   `json:"description,omitempty"`
+}
+
+// recordServiceSnapshot records the original configuration of a service,
+// unless one is recorded already: restoring always returns to the
+// configuration from before the first modification.
+func (st *persistState) recordServiceSnapshot(snapshot serviceSnapshot) {
+	if st.serviceSnapshot(snapshot.Name) == nil {
+		st.ServiceSnapshots = append(st.ServiceSnapshots, snapshot)
+	}
+}
+
+// serviceSnapshot returns the recorded original configuration of the
+// service name, or nil if GoPersist did not modify it.
+func (st *persistState) serviceSnapshot(name string) *serviceSnapshot {
+	for i := range st.ServiceSnapshots {
+		if strings.EqualFold(st.ServiceSnapshots[i].Name, name) {
+			return &st.ServiceSnapshots[i]
+		}
+	}
+	return nil
+}
+
+// forgetServiceSnapshot removes the recorded configuration of the service
+// name.
+func (st *persistState) forgetServiceSnapshot(name string) {
+	for i, snapshot := range st.ServiceSnapshots {
+		if strings.EqualFold(snapshot.Name, name) {
+			st.ServiceSnapshots = append(st.ServiceSnapshots[:i], st.ServiceSnapshots[i+1:]...)
+			return
+		}
+	}
+}
@@ -0,0 +1,109 @@
+//go:build windows
+
+package persist
+
+import (
+	"fmt"
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/mgr"
+	"strings"
+)
+
+// serviceStateNames maps service states to their names.
+var serviceStateNames = map[svc.State]string{
+	svc.Stopped:         "Stopped",
+	svc.StartPending:    "StartPending",
+	svc.StopPending:     "StopPending",
+	svc.Running:         "Running",
+	svc.ContinuePending: "ContinuePending",
+	svc.PausePending:    "PausePending",
+	svc.Paused:          "Paused",
+}
+
+// ServiceInfo is the configuration and state of an installed service.
+type ServiceInfo struct {
+	Name     string
+	State    string
+	Config   mgr.Config
+	Recovery *ServiceRecovery // nil if the service has no recovery actions
+	Triggers []ServiceTrigger
+}
+
+// QueryService returns the configuration and state of the service
+// serviceName.
+func QueryService(serviceName string) (*ServiceInfo, error) {
+	// Open a handle to the Service Control Manager
+	m, err := mgr.Connect()
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to service control manager: %w", err)
+	}
+	defer m.Disconnect()
+
+	// Open a handle to the service
+	s, err := m.OpenService(serviceName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open service %s: %w", serviceName, err)
+	}
+	defer s.Close()
+
+	info := &ServiceInfo{Name: serviceName}
+	status, err := s.Query()
+	if err != nil {
+		return nil, fmt.Errorf("failed to query service %s: %w", serviceName, err)
+	}
+	info.State = serviceStateNames[status.State]
+
+	if info.Config, err = s.Config(); err != nil {
+		return nil, fmt.Errorf("failed to read configuration of service %s: %w", serviceName, err)
+	}
+	if info.Recovery, err = readServiceRecovery(s); err != nil {
+		return nil, err
+	}
+	if info.Triggers, err = readServiceTriggers(s.Handle); err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+// String formats the service information for display.
+func (i *ServiceInfo) String() string {
+	startType := "unknown"
+	for name, st := range serviceStartTypes {
+		if st.startType == i.Config.StartType && st.delayed == i.Config.DelayedAutoStart && name != "manual" {
+			startType = name
+		}
+	}
+	account := i.Config.ServiceStartName
+	if account == "" {
+		account = "LocalSystem"
+	}
+
+	lines := []string{
+		fmt.Sprintf("Service '%s': state %s, start %s, account %s", i.Name, i.State, startType, account),
+		fmt.Sprintf("  Display name: %s", i.Config.DisplayName),
+		fmt.Sprintf("  Description: %s", valueOrNone(i.Config.Description)),
+		fmt.Sprintf("  Binary: %s", i.Config.BinaryPathName),
+		fmt.Sprintf("  Dependencies: %s", valueOrNone(strings.Join(i.Config.Dependencies, ", "))),
+		fmt.Sprintf("  Load order group: %s", valueOrNone(i.Config.LoadOrderGroup)),
+	}
+	if i.Recovery != nil {
+		lines = append(lines, fmt.Sprintf("  Recovery: %s", i.Recovery))
+	} else {
+		lines = append(lines, "  Recovery: none")
+	}
+	if len(i.Triggers) == 0 {
+		lines = append(lines, "  Triggers: none")
+	}
+	for _, trigger := range i.Triggers {
+		lines = append(lines, fmt.Sprintf("  Trigger: %s", trigger))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// valueOrNone returns value, or "none" if it is empty.
+func valueOrNone(value string) string {
+	if value == "" {
+		return "none"
+	}
+	return value
+}
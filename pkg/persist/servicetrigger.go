@@ -0,0 +1,333 @@
+//go:build windows
+
+package persist
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"golang.org/x/sys/windows"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+	"unsafe"
+)
+
+// Service trigger types, SERVICE_TRIGGER_TYPE_*.
+const (
+	ServiceTriggerDevice      = 1  // a device interface arrives
+	ServiceTriggerIPAddress   = 2  // the first IP address arrives or the last is removed
+	ServiceTriggerDomain      = 3  // the computer joins or leaves a domain
+	ServiceTriggerFirewall    = 4  // a firewall port opens or closes
+	ServiceTriggerGroupPolicy = 5  // machine or user policy changes
+	ServiceTriggerCustom      = 20 // an ETW provider writes an event
+)
+
+// Service trigger actions, SERVICE_TRIGGER_ACTION_*.
+const (
+	ServiceTriggerStart = 1
+	ServiceTriggerStop  = 2
+)
+
+// Service trigger data types, SERVICE_TRIGGER_DATA_TYPE_*.
+const (
+	ServiceTriggerDataBinary     = 1
+	ServiceTriggerDataString     = 2
+	ServiceTriggerDataLevel      = 3
+	ServiceTriggerDataKeywordAny = 4
+	ServiceTriggerDataKeywordAll = 5
+)
+
+// Trigger subtypes defined by Windows.
+var (
+	networkFirstIPArrival  = windows.GUID{Data1: 0x4f27f2de, Data2: 0x14e2, Data3: 0x430b, Data4: [8]byte{0xa5, 0x49, 0x7c, 0xd4, 0x8c, 0xbc, 0x82, 0x45}}
+	networkLastIPRemoval   = windows.GUID{Data1: 0xcc4ba62a, Data2: 0x162e, Data3: 0x4648, Data4: [8]byte{0x84, 0x7a, 0xb6, 0xbd, 0xf9, 0x93, 0xe3, 0x35}}
+	domainJoin             = windows.GUID{Data1: 0x1ce20aba, Data2: 0x9851, Data3: 0x4421, Data4: [8]byte{0x94, 0x30, 0x1d, 0xde, 0xb7, 0x66, 0xe8, 0x09}}
+	domainLeave            = windows.GUID{Data1: 0xddaf516e, Data2: 0x58c2, Data3: 0x4866, Data4: [8]byte{0x95, 0x74, 0xc3, 0xb6, 0x15, 0xd4, 0x2e, 0xa1}}
+	machinePolicyPresent   = windows.GUID{Data1: 0x659fcae6, Data2: 0x5bdb, Data3: 0x4da9, Data4: [8]byte{0xb1, 0xff, 0xca, 0x2a, 0x17, 0x8d, 0x46, 0xe0}}
+	userPolicyPresent      = windows.GUID{Data1: 0x54fb46c8, Data2: 0xf089, Data3: 0x464c, Data4: [8]byte{0xb1, 0xfd, 0x59, 0xd1, 0xb6, 0x2c, 0x3b, 0x50}}
+	serviceTriggerSubtypes = map[windows.GUID]string{
+		networkFirstIPArrival: "network",
+		networkLastIPRemoval:  "network-lost",
+		domainJoin:            "domain-join",
+		domainLeave:           "domain-leave",
+		machinePolicyPresent:  "policy scope=machine",
+		userPolicyPresent:     "policy scope=user",
+	}
+)
+
+// ServiceTrigger is an event that starts or stops a service.
+type ServiceTrigger struct {
+	Type    uint32 // ServiceTriggerDevice, ServiceTriggerIPAddress, ...
+	Action  uint32 // ServiceTriggerStart or ServiceTriggerStop
+	Subtype windows.GUID
+	Data    []ServiceTriggerData
+}
+
+// ServiceTriggerData is a data item a trigger event must match.
+type ServiceTriggerData struct {
+	Type uint32 // ServiceTriggerDataBinary, ServiceTriggerDataString, ...
+	Data []byte
+}
+
+// ParseServiceTrigger parses a trigger spec: a type followed by key=value
+// parameters, values quoted with ' or " if they contain white space:
+//
+//	network                                  first IP address available
+//	network-lost                             last IP address removed
+//	domain-join | domain-leave
+//	policy scope=machine|user                group policy applied
+//	device class={GUID} [id=<hardware id>]   device interface arrival
+//	etw provider={GUID} [keyword=0x10] [level=4]
+//
+// Every trigger takes action=start (default) or action=stop.
+func ParseServiceTrigger(spec string) (ServiceTrigger, error) {
+	fields, err := splitSpecFields(spec)
+	if err != nil {
+		return ServiceTrigger{}, err
+	}
+	if len(fields) == 0 {
+		return ServiceTrigger{}, fmt.Errorf("empty trigger")
+	}
+
+	params := map[string]string{}
+	for _, field := range fields[1:] {
+		key, value, ok := strings.Cut(field, "=")
+		if !ok {
+			return ServiceTrigger{}, fmt.Errorf("parameter %q is not in key=value form", field)
+		}
+		params[strings.ToLower(key)] = value
+	}
+
+	trigger := ServiceTrigger{Action: ServiceTriggerStart}
+	switch action := strings.ToLower(take(params, "action")); action {
+	case "", "start":
+	case "stop":
+		trigger.Action = ServiceTriggerStop
+	default:
+		return ServiceTrigger{}, fmt.Errorf("unknown trigger action %q, expected start or stop", action)
+	}
+
+	switch kind := strings.ToLower(fields[0]); kind {
+	case "network":
+		trigger.Type, trigger.Subtype = ServiceTriggerIPAddress, networkFirstIPArrival
+	case "network-lost":
+		trigger.Type, trigger.Subtype = ServiceTriggerIPAddress, networkLastIPRemoval
+	case "domain-join":
+		trigger.Type, trigger.Subtype = ServiceTriggerDomain, domainJoin
+	case "domain-leave":
+		trigger.Type, trigger.Subtype = ServiceTriggerDomain, domainLeave
+	case "policy":
+		trigger.Type = ServiceTriggerGroupPolicy
+		switch scope := strings.ToLower(take(params, "scope")); scope {
+		case "", "machine":
+			trigger.Subtype = machinePolicyPresent
+		case "user":
+			trigger.Subtype = userPolicyPresent
+		default:
+			return ServiceTrigger{}, fmt.Errorf("unknown policy scope %q, expected machine or user", scope)
+		}
+	case "device":
+		trigger.Type = ServiceTriggerDevice
+		if trigger.Subtype, err = triggerGUID("class", take(params, "class")); err != nil {
+			return ServiceTrigger{}, err
+		}
+		if id := take(params, "id"); id != "" {
+			trigger.Data = append(trigger.Data, ServiceTriggerData{Type: ServiceTriggerDataString, Data: multiStringBytes([]string{id})})
+		}
+	case "etw":
+		trigger.Type = ServiceTriggerCustom
+		if trigger.Subtype, err = triggerGUID("provider", take(params, "provider")); err != nil {
+			return ServiceTrigger{}, err
+		}
+		if keyword := take(params, "keyword"); keyword != "" {
+			k, err := strconv.ParseUint(keyword, 0, 64)
+			if err != nil {
+				return ServiceTrigger{}, fmt.Errorf("invalid keyword %q, expected a number such as 0x10", keyword)
+			}
+			trigger.Data = append(trigger.Data, ServiceTriggerData{Type: ServiceTriggerDataKeywordAny, Data: binary.LittleEndian.AppendUint64(nil, k)})
+		}
+		if level := take(params, "level"); level != "" {
+			l, err := strconv.ParseUint(level, 10, 8)
+			if err != nil {
+				return ServiceTrigger{}, fmt.Errorf("invalid level %q, expected 0 to 255", level)
+			}
+			trigger.Data = append(trigger.Data, ServiceTriggerData{Type: ServiceTriggerDataLevel, Data: []byte{byte(l)}})
+		}
+	default:
+		return ServiceTrigger{}, fmt.Errorf("unknown trigger %q, expected network, network-lost, domain-join, domain-leave, policy, device or etw", fields[0])
+	}
+
+	for key := range params {
+		return ServiceTrigger{}, fmt.Errorf("parameter %s is not supported by %s triggers", key, fields[0])
+	}
+	return trigger, nil
+}
+
+// triggerGUID parses the GUID parameter key of a trigger.
+func triggerGUID(key, value string) (windows.GUID, error) {
+	if value == "" {
+		return windows.GUID{}, fmt.Errorf("trigger requires %s", key)
+	}
+	if !classIDPattern.MatchString(value) {
+		return windows.GUID{}, fmt.Errorf("invalid %s %q, expected {XXXXXXXX-XXXX-XXXX-XXXX-XXXXXXXXXXXX}", key, value)
+	}
+	return windows.GUIDFromString(value)
+}
+
+// multiStringBytes encodes strings as a UTF-16 multi-string.
+func multiStringBytes(values []string) []byte {
+	var out []byte
+	for _, value := range values {
+		for _, c := range utf16.Encode([]rune(value + "\x00")) {
+			out = binary.LittleEndian.AppendUint16(out, c)
+		}
+	}
+	return binary.LittleEndian.AppendUint16(out, 0)
+}
+
+// String formats the trigger for display in the form ParseServiceTrigger
+// accepts, where possible.
+func (t ServiceTrigger) String() string {
+	var out string
+	if name, ok := serviceTriggerSubtypes[t.Subtype]; ok {
+		out = name
+	} else {
+		switch t.Type {
+		case ServiceTriggerDevice:
+			out = "device class=" + t.Subtype.String()
+		case ServiceTriggerCustom:
+			out = "etw provider=" + t.Subtype.String()
+		default:
+			out = fmt.Sprintf("type=%d subtype=%s", t.Type, t.Subtype)
+		}
+	}
+
+	for _, item := range t.Data {
+		switch {
+		case item.Type == ServiceTriggerDataString:
+			out += fmt.Sprintf(" id='%s'", strings.Join(decodeMultiString(item.Data), ","))
+		case item.Type == ServiceTriggerDataKeywordAny && len(item.Data) == 8:
+			out += fmt.Sprintf(" keyword=0x%X", binary.LittleEndian.Uint64(item.Data))
+		case item.Type == ServiceTriggerDataLevel && len(item.Data) == 1:
+			out += fmt.Sprintf(" level=%d", item.Data[0])
+		default:
+			out += fmt.Sprintf(" data%d=%X", item.Type, item.Data)
+		}
+	}
+	if t.Action == ServiceTriggerStop {
+		out += " action=stop"
+	}
+	return out
+}
+
+// decodeMultiString decodes a UTF-16 multi-string.
+func decodeMultiString(data []byte) []string {
+	var values []string
+	var current []uint16
+	for i := 0; i+1 < len(data); i += 2 {
+		c := binary.LittleEndian.Uint16(data[i:])
+		if c != 0 {
+			current = append(current, c)
+			continue
+		}
+		if len(current) == 0 {
+			break
+		}
+		values = append(values, string(utf16.Decode(current)))
+		current = nil
+	}
+	return values
+}
+
+// serviceTriggerInfo is SERVICE_TRIGGER_INFO.
+type serviceTriggerInfo struct {
+	count    uint32
+	triggers *serviceTrigger
+	reserved *byte
+}
+
+// serviceTrigger is SERVICE_TRIGGER.
+type serviceTrigger struct {
+	triggerType uint32
+	action      uint32
+	subtype     *windows.GUID
+	dataCount   uint32
+	dataItems   *serviceTriggerDataItem
+}
+
+// serviceTriggerDataItem is SERVICE_TRIGGER_SPECIFIC_DATA_ITEM.
+type serviceTriggerDataItem struct {
+	dataType uint32
+	size     uint32
+	data     *byte
+}
+
+// setServiceTriggers replaces the triggers of the service handle.
+func setServiceTriggers(service windows.Handle, triggers []ServiceTrigger) error {
+	raw := make([]serviceTrigger, len(triggers))
+	for i := range triggers {
+		t := &triggers[i]
+		raw[i] = serviceTrigger{triggerType: t.Type, action: t.Action, subtype: &t.Subtype}
+
+		items := make([]serviceTriggerDataItem, len(t.Data))
+		for j, d := range t.Data {
+			items[j] = serviceTriggerDataItem{dataType: d.Type, size: uint32(len(d.Data))}
+			if len(d.Data) > 0 {
+				items[j].data = &d.Data[0]
+			}
+		}
+		if len(items) > 0 {
+			raw[i].dataCount, raw[i].dataItems = uint32(len(items)), &items[0]
+		}
+	}
+
+	info := serviceTriggerInfo{count: uint32(len(raw))}
+	if len(raw) > 0 {
+		info.triggers = &raw[0]
+	}
+	if err := windows.ChangeServiceConfig2(service, windows.SERVICE_CONFIG_TRIGGER_INFO, (*byte)(unsafe.Pointer(&info))); err != nil {
+		return fmt.Errorf("failed to set service triggers: %w", err)
+	}
+	return nil
+}
+
+// readServiceTriggers returns the triggers of the service handle.
+func readServiceTriggers(service windows.Handle) ([]ServiceTrigger, error) {
+	var needed uint32
+	err := windows.QueryServiceConfig2(service, windows.SERVICE_CONFIG_TRIGGER_INFO, nil, 0, &needed)
+	if err != nil && !errors.Is(err, windows.ERROR_INSUFFICIENT_BUFFER) {
+		return nil, fmt.Errorf("failed to read service triggers: %w", err)
+	}
+	if needed == 0 {
+		return nil, nil
+	}
+
+	buf := make([]byte, needed)
+	if err := windows.QueryServiceConfig2(service, windows.SERVICE_CONFIG_TRIGGER_INFO, &buf[0], needed, &needed); err != nil {
+		return nil, fmt.Errorf("failed to read service triggers: %w", err)
+	}
+
+	info := (*serviceTriggerInfo)(unsafe.Pointer(&buf[0]))
+	if info.count == 0 {
+		return nil, nil
+	}
+	var triggers []ServiceTrigger
+	for _, raw := range unsafe.Slice(info.triggers, info.count) {
+		trigger := ServiceTrigger{Type: raw.triggerType, Action: raw.action}
+		if raw.subtype != nil {
+			trigger.Subtype = *raw.subtype
+		}
+		if raw.dataCount > 0 {
+			for _, item := range unsafe.Slice(raw.dataItems, raw.dataCount) {
+				data := ServiceTriggerData{Type: item.dataType}
+				if item.size > 0 {
+					data.Data = append([]byte(nil), unsafe.Slice(item.data, item.size)...)
+				}
+				trigger.Data = append(trigger.Data, data)
+			}
+		}
+		triggers = append(triggers, trigger)
+	}
+	return triggers, nil
+}
@@ -0,0 +1,153 @@
+//go:build windows
+
+package persist
+
+import (
+	"fmt"
+	"golang.org/x/sys/windows"
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/mgr"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// servicePollInterval is how often the service status is polled if status
+// change notifications are not available.
+const servicePollInterval = 250 * time.Millisecond
+
+var (
+	serviceNotifyOnce     sync.Once
+	serviceNotifyCallback uintptr
+
+	// abandonedNotifies keeps the notifications of timed out waits alive.
+	// The SCM writes to them when the status changes, even after the wait
+	// has given up.
+	abandonedNotifiesMu sync.Mutex
+	abandonedNotifies   []*windows.SERVICE_NOTIFY
+)
+
+// ServiceStoppedError is returned when a service stopped while waiting for
+// it to reach another state, typically because it failed to start.
+type ServiceStoppedError struct {
+	Name                    string
+	Win32ExitCode           uint32
+	ServiceSpecificExitCode uint32
+}
+
+// Error describes the exit code of the service.
+func (e *ServiceStoppedError) Error() string {
+	if e.Win32ExitCode == uint32(windows.ERROR_SERVICE_SPECIFIC_ERROR) {
+		return fmt.Sprintf("service %s stopped with service specific exit code %d (0x%X)", e.Name, e.ServiceSpecificExitCode, e.ServiceSpecificExitCode)
+	}
+	return fmt.Sprintf("service %s stopped with exit code %d: %v", e.Name, e.Win32ExitCode, windows.Errno(e.Win32ExitCode))
+}
+
+// waitServiceState waits until the service s reaches state. If the service
+// stops instead, it returns a *ServiceStoppedError at once. It waits for SCM
+// status change notifications and falls back to polling if they are not
+// available.
+func waitServiceState(s *mgr.Service, state svc.State, timeout time.Duration) error {
+	status, err := s.Query()
+	if err != nil {
+		return fmt.Errorf("failed to query service %s: %w", s.Name, err)
+	}
+
+	deadline := time.Now().Add(timeout)
+	for status.State != state && status.State != svc.Stopped {
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return fmt.Errorf("timeout: service %s did not reach state %s in time, it is %s", s.Name, serviceStateNames[state], serviceStateNames[status.State])
+		}
+
+		var notified bool
+		status, notified, err = notifyServiceStatus(s, state, remaining)
+		if err != nil {
+			return err
+		}
+		if !notified {
+			status, err = pollServiceStatus(s, state, remaining)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if status.State != state {
+		return &ServiceStoppedError{Name: s.Name, Win32ExitCode: status.Win32ExitCode, ServiceSpecificExitCode: status.ServiceSpecificExitCode}
+	}
+	return nil
+}
+
+// notifyServiceStatus waits up to timeout for the SCM to notify that the
+// service s entered state or stopped, and returns its status. notified is
+// false if notifications are not available.
+func notifyServiceStatus(s *mgr.Service, state svc.State, timeout time.Duration) (status svc.Status, notified bool, err error) {
+	serviceNotifyOnce.Do(func() {
+		// The callback only needs to wake the alertable wait below
+		serviceNotifyCallback = windows.NewCallback(func(notify uintptr) uintptr { return 0 })
+	})
+
+	// The notification is delivered as an APC to the thread that asked for
+	// it, and only while that thread waits alertably
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	notify := &windows.SERVICE_NOTIFY{
+		Version:        windows.SERVICE_NOTIFY_STATUS_CHANGE,
+		NotifyCallback: serviceNotifyCallback,
+	}
+	mask := uint32(windows.SERVICE_NOTIFY_STOPPED | 1<<(state-1)) // SERVICE_NOTIFY_* bits follow the service states
+	if err := windows.NotifyServiceStatusChange(s.Handle, mask, notify); err != nil {
+		return svc.Status{}, false, nil
+	}
+
+	deadline := time.Now().Add(timeout)
+	for {
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			abandonedNotifiesMu.Lock()
+			abandonedNotifies = append(abandonedNotifies, notify)
+			abandonedNotifiesMu.Unlock()
+			status, err := s.Query()
+			return status, true, err
+		}
+		// An APC of an earlier, abandoned wait on this thread leaves notify
+		// untouched
+		if windows.SleepEx(uint32(remaining/time.Millisecond)+1, true) == windows.WAIT_IO_COMPLETION && notify.ServiceStatus.CurrentState != 0 {
+			break
+		}
+	}
+
+	if notify.NotificationStatus != 0 {
+		return svc.Status{}, false, fmt.Errorf("failed to wait for service %s: %w", s.Name, windows.Errno(notify.NotificationStatus))
+	}
+	return svc.Status{
+		State:                   svc.State(notify.ServiceStatus.CurrentState),
+		Win32ExitCode:           notify.ServiceStatus.Win32ExitCode,
+		ServiceSpecificExitCode: notify.ServiceStatus.ServiceSpecificExitCode,
+	}, true, nil
+}
+
+// pollServiceStatus polls the status of the service s until it enters state
+// or stops, or timeout has passed.
+func pollServiceStatus(s *mgr.Service, state svc.State, timeout time.Duration) (svc.Status, error) {
+	deadline := time.After(timeout)
+	ticker := time.NewTicker(servicePollInterval)
+	defer ticker.Stop()
+	for {
+		status, err := s.Query()
+		if err != nil {
+			return svc.Status{}, fmt.Errorf("failed to query service %s: %w", s.Name, err)
+		}
+		if status.State == state || status.State == svc.Stopped {
+			return status, nil
+		}
+
+		select {
+		case <-deadline:
+			return status, nil
+		case <-ticker.C:
+		}
+	}
+}
@@ -1,19 +1,54 @@
+//go:build windows
+
 package persist
 
 import (
 	"fmt"
+	"golang.org/x/sys/windows"
 	"io"
 	"os"
 	"path/filepath"
 )
 
-// DropFileToStartup moves a file to the Startup folder
-func DropFileToStartup(filePath, fileName string) error {
-	startupFolder := filepath.Join(os.Getenv("APPDATA"), "Microsoft", "Windows", "Start Menu", "Programs", "Startup")
+// startupRequirement is what writing to the Startup folder needs: the
+// folder of the current user is writable by the user, the all users
+// folder only by elevated administrators.
+func startupRequirement(allUsers bool) Requirement {
+	if allUsers {
+		return RequireElevation
+	}
+	return RequireNothing
+}
+
+// startupFolderPath returns the Startup folder of the current user, or the one
+// of all users, after checking the caller may write to it.
+func startupFolderPath(op string, allUsers bool) (string, error) {
+	if err := checkRequirement(op, startupRequirement(allUsers)); err != nil {
+		return "", err
+	}
+
+	folderID := windows.FOLDERID_Startup
+	if allUsers {
+		folderID = windows.FOLDERID_CommonStartup
+	}
+	folder, err := windows.KnownFolderPath(folderID, 0)
+	if err != nil {
+		return "", fmt.Errorf("failed to locate Startup folder: %w", err)
+	}
+	return folder, nil
+}
+
+// DropFileToStartup moves a file to the Startup folder of the current user,
+// or of all users if allUsers is set
+func DropFileToStartup(filePath, fileName string, allUsers bool) error {
+	startupFolder, err := startupFolderPath("add startup file "+fileName, allUsers)
+	if err != nil {
+		return err
+	}
 	destinationPath := filepath.Join(startupFolder, fileName)
 
 	// Copy the file to the Startup folder
-	err := copyFile(filePath, destinationPath)
+	err = copyFile(filePath, destinationPath)
 	if err != nil {
 		return fmt.Errorf("failed to copy file: %w", err)
 	}
@@ -44,18 +79,28 @@ func copyFile(src, dst string) error {
 	return nil
 }
 
-// CreateStartupBatchFile creates a batch file in the Startup folder
-func CreateStartupBatchFile(command, arguments, fileName string) error {
-	startupFolder := filepath.Join(os.Getenv("APPDATA"), "Microsoft", "Windows", "Start Menu", "Programs", "Startup")
+// CreateStartupBatchFile creates a batch file in the Startup folder of the
+// current user, or of all users if allUsers is set, that runs command with
+// args, escaped for cmd.exe with BuildBatchCommandLine.
+func CreateStartupBatchFile(command string, args []string, fileName string, allUsers bool) error {
+	line, err := BuildBatchCommandLine(command, args)
+	if err != nil {
+		return fmt.Errorf("invalid command: %w", err)
+	}
+
+	startupFolder, err := startupFolderPath("add startup entry "+fileName, allUsers)
+	if err != nil {
+		return err
+	}
 	batchFilePath := filepath.Join(startupFolder, fileName+".bat")
 
 	// Create the batch file content
 	batchContent := fmt.Sprintf(`@echo off
-%s %s
-`, command, arguments)
+%s
+`, line)
 
 	// Write the batch content to the file
-	err := os.WriteFile(batchFilePath, []byte(batchContent), 0644)
+	err = os.WriteFile(batchFilePath, []byte(batchContent), 0644)
 	if err != nil {
 		return fmt.Errorf("failed to create batch file: %w", err)
 	}
@@ -64,9 +109,13 @@ func CreateStartupBatchFile(command, arguments, fileName string) error {
 	return nil
 }
 
-// RemoveFileFromStartup deletes a file from the Startup folder
-func RemoveFileFromStartup(fileName string) error {
-	startupFolder := filepath.Join(os.Getenv("APPDATA"), "Microsoft", "Windows", "Start Menu", "Programs", "Startup")
+// RemoveFileFromStartup deletes a file from the Startup folder of the
+// current user, or of all users if allUsers is set
+func RemoveFileFromStartup(fileName string, allUsers bool) error {
+	startupFolder, err := startupFolderPath("remove startup entry "+fileName, allUsers)
+	if err != nil {
+		return err
+	}
 	destinationPath := filepath.Join(startupFolder, fileName+".bat")
 
 	// Check if the file exists
@@ -74,10 +123,8 @@ func RemoveFileFromStartup(fileName string) error {
 		return fmt.Errorf("file does not exist: %s", destinationPath)
 	}
 
-	fmt.Printf(destinationPath)
-
 	// Remove the file from the Startup folder
-	err := os.Remove(destinationPath)
+	err = os.Remove(destinationPath)
 	if err != nil {
 		return fmt.Errorf("failed to remove file: %w", err)
 	}
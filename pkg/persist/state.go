@@ -0,0 +1,82 @@
+package persist
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// persistState records what GoPersist created or changed, so that a later
+// remove can undo exactly that and nothing else. It is stored as JSON in the
+// user's configuration directory.
+type persistState struct {
+	// TaskFolders are the Task Scheduler folders GoPersist created.
+	TaskFolders []string `json:"taskFolders,omitempty"`
+
+	// ServiceSnapshots are the original configurations of the services
+	// GoPersist modified.
+	ServiceSnapshots []serviceSnapshot `json:"serviceSnapshots,omitempty"`
+
+	// RegistryAppends are the entries GoPersist appended to registry list
+	// values.
+	RegistryAppends []registryAppend `json:"registryAppends,omitempty"`
+
+	// RegistryKeys are the registry keys GoPersist created, as full paths
+	// including the hive.
+	RegistryKeys []string `json:"registryKeys,omitempty"`
+}
+
+// statePath returns the location of the state file.
+func statePath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to locate configuration directory: %w", err)
+	}
+	return filepath.Join(dir, "GoPersist", "state.json"), nil
+}
+
+// loadState reads the state file, returning an empty state if it does not
+// exist yet.
+func loadState() (*persistState, error) {
+	path, err := statePath()
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return &persistState{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read state file: %w", err)
+	}
+
+	var st persistState
+	if err := json.Unmarshal(data, &st); err != nil {
+		return nil, fmt.Errorf("failed to parse state file %s: %w", path, err)
+	}
+	return &st, nil
+}
+
+// save writes the state file.
+func (st *persistState) save() error {
+	path, err := statePath()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("failed to create state directory: %w", err)
+	}
+
+	data, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode state: %w", err)
+	}
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		return fmt.Errorf("failed to write state file: %w", err)
+	}
+	return nil
+}
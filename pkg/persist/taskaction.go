@@ -0,0 +1,83 @@
+package persist
+
+import (
+	"encoding/xml"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// maxTaskActions is the number of actions Task Scheduler allows per task.
+const maxTaskActions = 32
+
+// classIDPattern matches a COM class ID such as {0F87369F-A4E5-4CFC-BD3E-73E6154572DD}.
+var classIDPattern = regexp.MustCompile(`^\{[0-9A-Fa-f]{8}-[0-9A-Fa-f]{4}-[0-9A-Fa-f]{4}-[0-9A-Fa-f]{4}-[0-9A-Fa-f]{12}\}$`)
+
+// NewExecAction creates an action that starts a program.
+func NewExecAction(command, args, workingDir string) Action {
+	return Action{
+		XMLName:          xml.Name{Local: "Exec"},
+		Command:          command,
+		Arguments:        args,
+		WorkingDirectory: workingDir,
+	}
+}
+
+// NewComHandlerAction creates an action that runs a COM handler, passing it
+// data as its start parameter.
+func NewComHandlerAction(classID, data string) (Action, error) {
+	if !classIDPattern.MatchString(classID) {
+		return Action{}, fmt.Errorf("invalid COM class ID %q, expected {XXXXXXXX-XXXX-XXXX-XXXX-XXXXXXXXXXXX}", classID)
+	}
+	return Action{
+		XMLName: xml.Name{Local: "ComHandler"},
+		ClassID: classID,
+		Data:    data,
+	}, nil
+}
+
+// ParseTaskAction parses an action spec: a type followed by key=value
+// parameters, values quoted with ' or " if they contain white space:
+//
+//	exec cmd=C:\Windows\System32\cmd.exe args="/c echo hi" dir=C:\Temp
+//	com class={0F87369F-A4E5-4CFC-BD3E-73E6154572DD} data="start"
+func ParseTaskAction(spec string) (Action, error) {
+	fields, err := splitSpecFields(spec)
+	if err != nil {
+		return Action{}, err
+	}
+	if len(fields) == 0 {
+		return Action{}, fmt.Errorf("empty action")
+	}
+
+	params := map[string]string{}
+	for _, field := range fields[1:] {
+		key, value, ok := strings.Cut(field, "=")
+		if !ok {
+			return Action{}, fmt.Errorf("parameter %q is not in key=value form", field)
+		}
+		params[strings.ToLower(key)] = value
+	}
+
+	var action Action
+	switch strings.ToLower(fields[0]) {
+	case "exec":
+		command := take(params, "cmd")
+		if command == "" {
+			return Action{}, fmt.Errorf("exec action requires cmd")
+		}
+		action = NewExecAction(command, take(params, "args"), take(params, "dir"))
+	case "com":
+		action, err = NewComHandlerAction(take(params, "class"), take(params, "data"))
+		if err != nil {
+			return Action{}, err
+		}
+	default:
+		return Action{}, fmt.Errorf("unknown action type %q, expected exec or com", fields[0])
+	}
+
+	for key := range params {
+		return Action{}, fmt.Errorf("parameter %s is not supported by %s actions", key, fields[0])
+	}
+	return action, nil
+}
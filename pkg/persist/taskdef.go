@@ -0,0 +1,233 @@
+package persist
+
+import "encoding/xml"
+
+// TaskDefinition mirrors the Task Scheduler task XML schema. It is the model
+// used both for tasks registered through SchTask and for tasks read from disk.
+type TaskDefinition struct {
+	XMLName          xml.Name          `xml:"Task"`
+	Version          string            `xml:"version,attr,omitempty"`
+	Xmlns            string            `xml:"xmlns,attr,omitempty"`
+	RegistrationInfo *RegistrationInfo `xml:"RegistrationInfo,omitempty"`
+	Triggers         *Triggers         `xml:"Triggers,omitempty"`
+	Principals       *Principals       `xml:"Principals,omitempty"`
+	Settings         *TaskSettings     `xml:"Settings,omitempty"`
+	Actions          Actions           `xml:"Actions"`
+}
+
+// TriggerList returns the triggers of the task, or nil if it has none.
+func (d *TaskDefinition) TriggerList() []Trigger {
+	if d.Triggers == nil {
+		return nil
+	}
+	return d.Triggers.Items
+}
+
+// PrincipalList returns the principals of the task, or nil if it has none.
+func (d *TaskDefinition) PrincipalList() []Principal {
+	if d.Principals == nil {
+		return nil
+	}
+	return d.Principals.Items
+}
+
+// RegistrationInfo holds the administrative details of a task.
+type RegistrationInfo struct {
+	Date               string `xml:"Date,omitempty"`
+	Author             string `xml:"Author,omitempty"`
+	Version            string `xml:"Version,omitempty"`
+	Description        string `xml:"Description,omitempty"`
+	URI                string `xml:"URI,omitempty"`
+	Source             string `xml:"Source,omitempty"`
+	Documentation      string `xml:"Documentation,omitempty"`
+	SecurityDescriptor string `xml:"SecurityDescriptor,omitempty"`
+}
+
+// Triggers is the ordered list of triggers of a task.
+type Triggers struct {
+	Items []Trigger `xml:",any"`
+}
+
+// Trigger is a single task trigger. The element name (TimeTrigger,
+// CalendarTrigger, LogonTrigger, ...) determines which fields apply.
+type Trigger struct {
+	XMLName                  xml.Name                  `xml:""`
+	ID                       string                    `xml:"id,attr,omitempty"`
+	Repetition               *Repetition               `xml:"Repetition,omitempty"`
+	StartBoundary            string                    `xml:"StartBoundary,omitempty"`
+	EndBoundary              string                    `xml:"EndBoundary,omitempty"`
+	ExecutionTimeLimit       string                    `xml:"ExecutionTimeLimit,omitempty"`
+	Enabled                  *bool                     `xml:"Enabled,omitempty"`
+	Subscription             string                    `xml:"Subscription,omitempty"`
+	StateChange              string                    `xml:"StateChange,omitempty"`
+	UserID                   string                    `xml:"UserId,omitempty"`
+	Delay                    string                    `xml:"Delay,omitempty"`
+	RandomDelay              string                    `xml:"RandomDelay,omitempty"`
+	ScheduleByDay            *ScheduleByDay            `xml:"ScheduleByDay,omitempty"`
+	ScheduleByWeek           *ScheduleByWeek           `xml:"ScheduleByWeek,omitempty"`
+	ScheduleByMonth          *ScheduleByMonth          `xml:"ScheduleByMonth,omitempty"`
+	ScheduleByMonthDayOfWeek *ScheduleByMonthDayOfWeek `xml:"ScheduleByMonthDayOfWeek,omitempty"`
+}
+
+// Kind returns the trigger element name, e.g. "LogonTrigger".
+func (t Trigger) Kind() string {
+	return t.XMLName.Local
+}
+
+// Repetition describes how often a trigger fires again after it started.
+type Repetition struct {
+	Interval          string `xml:"Interval,omitempty"`
+	Duration          string `xml:"Duration,omitempty"`
+	StopAtDurationEnd *bool  `xml:"StopAtDurationEnd,omitempty"`
+}
+
+// ScheduleByDay runs a calendar trigger every DaysInterval days.
+type ScheduleByDay struct {
+	DaysInterval int `xml:"DaysInterval,omitempty"`
+}
+
+// ScheduleByWeek runs a calendar trigger on the given days every
+// WeeksInterval weeks.
+type ScheduleByWeek struct {
+	WeeksInterval int       `xml:"WeeksInterval,omitempty"`
+	DaysOfWeek    *NameList `xml:"DaysOfWeek,omitempty"`
+}
+
+// ScheduleByMonth runs a calendar trigger on the given days of the given
+// months.
+type ScheduleByMonth struct {
+	DaysOfMonth *DaysOfMonth `xml:"DaysOfMonth,omitempty"`
+	Months      *NameList    `xml:"Months,omitempty"`
+}
+
+// ScheduleByMonthDayOfWeek runs a calendar trigger on the given days of the
+// week in the given weeks of the given months, e.g. the last Friday of March.
+type ScheduleByMonthDayOfWeek struct {
+	Weeks      *Weeks    `xml:"Weeks,omitempty"`
+	DaysOfWeek *NameList `xml:"DaysOfWeek,omitempty"`
+	Months     *NameList `xml:"Months,omitempty"`
+}
+
+// Weeks lists weeks of the month, 1 to 4 or Last, as <Week> elements.
+type Weeks struct {
+	Weeks []string `xml:"Week"`
+}
+
+// DaysOfMonth lists days of the month as <Day> elements.
+type DaysOfMonth struct {
+	Days []string `xml:"Day"`
+}
+
+// NameList is a set of empty marker elements such as <Monday/> or
+// <January/>. Names holds the element names in document order.
+type NameList struct {
+	Names []string
+}
+
+// MarshalXML writes each name as an empty element.
+func (d NameList) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	if err := e.EncodeToken(start); err != nil {
+		return err
+	}
+	for _, name := range d.Names {
+		el := xml.StartElement{Name: xml.Name{Local: name}}
+		if err := e.EncodeToken(el); err != nil {
+			return err
+		}
+		if err := e.EncodeToken(el.End()); err != nil {
+			return err
+		}
+	}
+	return e.EncodeToken(start.End())
+}
+
+// UnmarshalXML collects the names of the child elements.
+func (d *NameList) UnmarshalXML(dec *xml.Decoder, start xml.StartElement) error {
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			d.Names = append(d.Names, t.Name.Local)
+			if err := dec.Skip(); err != nil {
+				return err
+			}
+		case xml.EndElement:
+			return nil
+		}
+	}
+}
+
+// Principals holds the security contexts a task can run under.
+type Principals struct {
+	Items []Principal `xml:"Principal"`
+}
+
+// Principal is the security context of a task.
+type Principal struct {
+	ID        string `xml:"id,attr,omitempty"`
+	UserID    string `xml:"UserId,omitempty"`
+	GroupID   string `xml:"GroupId,omitempty"`
+	LogonType string `xml:"LogonType,omitempty"`
+	RunLevel  string `xml:"RunLevel,omitempty"`
+}
+
+// TaskSettings holds the settings that control how Task Scheduler runs a
+// task.
+type TaskSettings struct {
+	MultipleInstancesPolicy    string            `xml:"MultipleInstancesPolicy,omitempty"`
+	DisallowStartIfOnBatteries *bool             `xml:"DisallowStartIfOnBatteries,omitempty"`
+	StopIfGoingOnBatteries     *bool             `xml:"StopIfGoingOnBatteries,omitempty"`
+	AllowHardTerminate         *bool             `xml:"AllowHardTerminate,omitempty"`
+	StartWhenAvailable         *bool             `xml:"StartWhenAvailable,omitempty"`
+	RunOnlyIfNetworkAvailable  *bool             `xml:"RunOnlyIfNetworkAvailable,omitempty"`
+	IdleSettings               *IdleSettings     `xml:"IdleSettings,omitempty"`
+	AllowStartOnDemand         *bool             `xml:"AllowStartOnDemand,omitempty"`
+	Enabled                    *bool             `xml:"Enabled,omitempty"`
+	Hidden                     *bool             `xml:"Hidden,omitempty"`
+	RunOnlyIfIdle              *bool             `xml:"RunOnlyIfIdle,omitempty"`
+	WakeToRun                  *bool             `xml:"WakeToRun,omitempty"`
+	ExecutionTimeLimit         string            `xml:"ExecutionTimeLimit,omitempty"`
+	Priority                   *int              `xml:"Priority,omitempty"`
+	RestartOnFailure           *RestartOnFailure `xml:"RestartOnFailure,omitempty"`
+}
+
+// RestartOnFailure makes Task Scheduler restart a failed task up to Count
+// times, waiting Interval between attempts.
+type RestartOnFailure struct {
+	Interval string `xml:"Interval"`
+	Count    int    `xml:"Count"`
+}
+
+// IdleSettings controls how a task behaves when the computer is idle.
+type IdleSettings struct {
+	Duration      string `xml:"Duration,omitempty"`
+	WaitTimeout   string `xml:"WaitTimeout,omitempty"`
+	StopOnIdleEnd *bool  `xml:"StopOnIdleEnd,omitempty"`
+	RestartOnIdle *bool  `xml:"RestartOnIdle,omitempty"`
+}
+
+// Actions is the ordered list of actions of a task.
+type Actions struct {
+	Context string   `xml:"Context,attr,omitempty"`
+	Items   []Action `xml:",any"`
+}
+
+// Action is a single task action. The element name (Exec, ComHandler, ...)
+// determines which fields apply.
+type Action struct {
+	XMLName          xml.Name `xml:""`
+	ID               string   `xml:"id,attr,omitempty"`
+	Command          string   `xml:"Command,omitempty"`
+	Arguments        string   `xml:"Arguments,omitempty"`
+	WorkingDirectory string   `xml:"WorkingDirectory,omitempty"`
+	ClassID          string   `xml:"ClassId,omitempty"`
+	Data             string   `xml:"Data,omitempty"`
+}
+
+// Kind returns the action element name, e.g. "Exec".
+func (a Action) Kind() string {
+	return a.XMLName.Local
+}
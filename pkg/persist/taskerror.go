@@ -0,0 +1,123 @@
+package persist
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Errors a TaskSchedulerError can be matched against with errors.Is.
+var (
+	ErrTaskNotFound           = errors.New("task or folder not found")
+	ErrTaskExists             = errors.New("task or folder already exists")
+	ErrTaskAccessDenied       = errors.New("access denied")
+	ErrTaskInvalidValue       = errors.New("task XML contains an invalid value")
+	ErrTaskMissingElement     = errors.New("task XML is missing a required element")
+	ErrTaskUnsupportedElement = errors.New("task XML contains an element not supported by its task version")
+	ErrTaskMalformedXML       = errors.New("task XML is malformed")
+	ErrTaskAccountNotFound    = errors.New("account not found")
+	ErrTaskUnsupportedLogon   = errors.New("logon type not supported for the account")
+	ErrTaskLogonFailure       = errors.New("account logon failed")
+)
+
+// taskErrorCodes maps Task Scheduler and Win32 HRESULTs to their errors and
+// a hint on how to fix them.
+var taskErrorCodes = map[uint32]struct {
+	kind error
+	hint string
+}{
+	0x80070002: {ErrTaskNotFound, ""},
+	0x80070003: {ErrTaskNotFound, ""},
+	0x800700B7: {ErrTaskExists, ""},
+	0x80070005: {ErrTaskAccessDenied, "run GoPersist as an elevated administrator or choose a task folder and principal the current user may write"},
+	0x80041316: {ErrTaskUnsupportedElement, "check the Version attribute of the Task element, Task Scheduler " + taskSchemaVersion + " is used by default"},
+	0x80041317: {ErrTaskUnsupportedElement, "elements must be in the " + taskSchemaNamespace + " namespace"},
+	0x80041318: {ErrTaskInvalidValue, "check the format and range of the value"},
+	0x80041319: {ErrTaskMissingElement, ""},
+	0x8004131A: {ErrTaskMalformedXML, ""},
+	0x80041310: {ErrTaskAccountNotFound, "check the spelling of the user or group, including its DOMAIN\\ prefix"},
+	0x80041314: {ErrTaskUnsupportedLogon, "choose another -sch-logon, service accounts and groups take none"},
+	0x80070534: {ErrTaskAccountNotFound, "check the spelling of the user or group, including its DOMAIN\\ prefix"},
+	0x80070569: {ErrTaskLogonFailure, "the account lacks the 'Log on as a batch job' right"},
+	0x8007052E: {ErrTaskLogonFailure, "check the password of the account"},
+}
+
+// taskErrorLocation matches the position Task Scheduler appends to XML
+// errors, e.g. (8,4):Interval:P1X.
+var taskErrorLocation = regexp.MustCompile(`\((\d+),(\d+)\):([^:\s]+):?(.*)`)
+
+// TaskSchedulerError is a Task Scheduler failure decoded from a COM error.
+// For errors in the task XML, Field names the offending element or attribute.
+type TaskSchedulerError struct {
+	Op          string // operation that failed, e.g. "register task \Acme\Check"
+	Code        uint32 // HRESULT reported by Task Scheduler
+	Description string // message reported by Task Scheduler, if any
+	Field       string // offending XML element or attribute, if reported
+	Value       string // offending value, if reported
+	Line        int    // line of Field in the task XML, if reported
+	Column      int    // column of Field in the task XML, if reported
+	Err         error  // underlying COM error
+
+	kind error
+	hint string
+}
+
+// newTaskSchedulerError decodes the HRESULT code and description reported
+// for op into a TaskSchedulerError.
+func newTaskSchedulerError(op string, code uint32, description string, err error) *TaskSchedulerError {
+	e := &TaskSchedulerError{
+		Op:          op,
+		Code:        code,
+		Description: strings.TrimSpace(description),
+		Err:         err,
+	}
+	if known, ok := taskErrorCodes[code]; ok {
+		e.kind, e.hint = known.kind, known.hint
+	}
+
+	// Pick out the element Task Scheduler complains about, if any
+	if m := taskErrorLocation.FindStringSubmatch(e.Description); m != nil {
+		e.Line, _ = strconv.Atoi(m[1])
+		e.Column, _ = strconv.Atoi(m[2])
+		e.Field = m[3]
+		e.Value = strings.TrimSpace(m[4])
+	}
+	return e
+}
+
+// Error describes the failure, the offending field and a hint if known.
+func (e *TaskSchedulerError) Error() string {
+	msg := fmt.Sprintf("failed to %s: ", e.Op)
+	if e.kind != nil {
+		msg += e.kind.Error()
+	} else if e.Description != "" {
+		msg += e.Description
+	} else {
+		msg += e.Err.Error()
+	}
+	msg += fmt.Sprintf(" (0x%08X)", e.Code)
+
+	if e.Field != "" {
+		msg += fmt.Sprintf(": field %s", e.Field)
+		if e.Value != "" {
+			msg += fmt.Sprintf(" value '%s'", e.Value)
+		}
+		msg += fmt.Sprintf(" at line %d, column %d", e.Line, e.Column)
+	}
+	if e.hint != "" {
+		msg += "; " + e.hint
+	}
+	return msg
+}
+
+// Unwrap returns the underlying COM error.
+func (e *TaskSchedulerError) Unwrap() error {
+	return e.Err
+}
+
+// Is reports whether target is the Err* value for the error's HRESULT.
+func (e *TaskSchedulerError) Is(target error) bool {
+	return e.kind != nil && e.kind == target
+}
@@ -0,0 +1,30 @@
+package persist
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestTaskSchedulerErrorKinds(t *testing.T) {
+	tests := []struct {
+		code uint32
+		want error
+	}{
+		{0x80070002, ErrTaskNotFound},
+		{0x80041310, ErrTaskAccountNotFound},
+		{0x80070534, ErrTaskAccountNotFound},
+		{0x80041314, ErrTaskUnsupportedLogon},
+		{0x8007052E, ErrTaskLogonFailure},
+	}
+	for _, tt := range tests {
+		err := newTaskSchedulerError(`register task \Test`, tt.code, "", errors.New("COM error"))
+		if !errors.Is(err, tt.want) {
+			t.Errorf("0x%08X: errors.Is(%v, %v) = false", tt.code, err, tt.want)
+		}
+	}
+
+	err := newTaskSchedulerError(`register task \Test`, 0x80041314, "", errors.New("COM error"))
+	if errors.Is(err, ErrTaskAccountNotFound) {
+		t.Error("0x80041314 reported as account not found")
+	}
+}
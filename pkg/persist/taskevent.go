@@ -0,0 +1,204 @@
+package persist
+
+import (
+	"encoding/xml"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// EventQuery selects the event log records that fire an event trigger.
+// Either Channel with optional Provider and EventIDs, or a raw XPath filter
+// (with Channel), or a complete <QueryList> document can be given.
+type EventQuery struct {
+	Channel  string
+	Provider string
+	EventIDs []int
+	// XPath is a raw filter such as *[System[EventID=7045]]. It replaces
+	// the filter built from Provider and EventIDs.
+	XPath string
+}
+
+// queryList is the event log query document used as a trigger subscription.
+type queryList struct {
+	XMLName xml.Name     `xml:"QueryList"`
+	Queries []eventQuery `xml:"Query"`
+}
+
+type eventQuery struct {
+	ID      string        `xml:"Id,attr"`
+	Path    string        `xml:"Path,attr,omitempty"`
+	Selects []eventSelect `xml:"Select"`
+}
+
+type eventSelect struct {
+	Path  string `xml:"Path,attr"`
+	XPath string `xml:",chardata"`
+}
+
+// Filter returns the XPath filter of the query.
+func (q EventQuery) Filter() string {
+	if q.XPath != "" {
+		return q.XPath
+	}
+
+	var conditions []string
+	if q.Provider != "" {
+		conditions = append(conditions, fmt.Sprintf("Provider[@Name='%s']", q.Provider))
+	}
+	if len(q.EventIDs) > 0 {
+		ids := make([]string, len(q.EventIDs))
+		for i, id := range q.EventIDs {
+			ids[i] = "EventID=" + strconv.Itoa(id)
+		}
+		if len(ids) == 1 {
+			conditions = append(conditions, ids[0])
+		} else {
+			conditions = append(conditions, "("+strings.Join(ids, " or ")+")")
+		}
+	}
+	if len(conditions) == 0 {
+		return "*"
+	}
+	return "*[System[" + strings.Join(conditions, " and ") + "]]"
+}
+
+// Subscription validates the query and renders it as the <QueryList>
+// document Task Scheduler expects in an event trigger.
+func (q EventQuery) Subscription() (string, error) {
+	if strings.HasPrefix(strings.TrimSpace(q.XPath), "<") {
+		return validateQueryList(q.XPath)
+	}
+
+	if q.Channel == "" {
+		return "", fmt.Errorf("an event query requires a channel, e.g. Security or System")
+	}
+	if strings.ContainsAny(q.Channel, `<>"'`) {
+		return "", fmt.Errorf("invalid channel %q", q.Channel)
+	}
+	if strings.ContainsAny(q.Provider, `<>"'[]`) {
+		return "", fmt.Errorf("invalid provider %q", q.Provider)
+	}
+	for _, id := range q.EventIDs {
+		if id < 0 || id > 65535 {
+			return "", fmt.Errorf("event ID %d out of range 0-65535", id)
+		}
+	}
+
+	filter := q.Filter()
+	if err := validateEventXPath(filter); err != nil {
+		return "", fmt.Errorf("invalid XPath %q: %w", filter, err)
+	}
+
+	out, err := xml.Marshal(queryList{Queries: []eventQuery{{
+		ID:      "0",
+		Path:    q.Channel,
+		Selects: []eventSelect{{Path: q.Channel, XPath: filter}},
+	}}})
+	if err != nil {
+		return "", fmt.Errorf("failed to render event query: %w", err)
+	}
+	return string(out), nil
+}
+
+// validateQueryList checks a complete <QueryList> document and returns it
+// unchanged.
+func validateQueryList(doc string) (string, error) {
+	var list queryList
+	if err := xml.Unmarshal([]byte(doc), &list); err != nil {
+		return "", fmt.Errorf("invalid event QueryList: %w", err)
+	}
+	if len(list.Queries) == 0 {
+		return "", fmt.Errorf("invalid event QueryList: no Query element")
+	}
+	for _, query := range list.Queries {
+		if len(query.Selects) == 0 {
+			return "", fmt.Errorf("invalid event QueryList: query %s has no Select element", query.ID)
+		}
+		for _, sel := range query.Selects {
+			if sel.Path == "" && query.Path == "" {
+				return "", fmt.Errorf("invalid event QueryList: Select without a channel Path")
+			}
+			if err := validateEventXPath(strings.TrimSpace(sel.XPath)); err != nil {
+				return "", fmt.Errorf("invalid XPath %q: %w", sel.XPath, err)
+			}
+		}
+	}
+	return doc, nil
+}
+
+// validateEventXPath performs the structural checks the event log XPath
+// subset requires: the filter selects * or Event, and brackets, parentheses
+// and quotes are balanced.
+func validateEventXPath(filter string) error {
+	if filter == "" {
+		return fmt.Errorf("empty filter")
+	}
+	if !strings.HasPrefix(filter, "*") && !strings.HasPrefix(filter, "Event") {
+		return fmt.Errorf("filter must start with * or Event")
+	}
+
+	var stack []rune
+	var quote rune
+	for _, r := range filter {
+		if quote != 0 {
+			if r == quote {
+				quote = 0
+			}
+			continue
+		}
+		switch r {
+		case '\'', '"':
+			quote = r
+		case '[', '(':
+			stack = append(stack, r)
+		case ']', ')':
+			open := '['
+			if r == ')' {
+				open = '('
+			}
+			if len(stack) == 0 || stack[len(stack)-1] != open {
+				return fmt.Errorf("unbalanced %q", r)
+			}
+			stack = stack[:len(stack)-1]
+		}
+	}
+	if quote != 0 {
+		return fmt.Errorf("unterminated string")
+	}
+	if len(stack) > 0 {
+		return fmt.Errorf("unclosed %q", stack[len(stack)-1])
+	}
+	return nil
+}
+
+// newEventTrigger creates an event trigger from the channel, provider, ids
+// and query trigger parameters.
+func newEventTrigger(params map[string]string) (Trigger, error) {
+	query := EventQuery{
+		Channel:  take(params, "channel"),
+		Provider: take(params, "provider"),
+		XPath:    take(params, "query"),
+	}
+	if ids := take(params, "ids"); ids != "" {
+		if query.XPath != "" {
+			return Trigger{}, fmt.Errorf("ids and query cannot be combined")
+		}
+		for _, id := range strings.Split(ids, ",") {
+			n, err := strconv.Atoi(strings.TrimSpace(id))
+			if err != nil {
+				return Trigger{}, fmt.Errorf("invalid event ID %q", id)
+			}
+			query.EventIDs = append(query.EventIDs, n)
+		}
+	}
+	if query.Provider != "" && query.XPath != "" {
+		return Trigger{}, fmt.Errorf("provider and query cannot be combined")
+	}
+
+	subscription, err := query.Subscription()
+	if err != nil {
+		return Trigger{}, err
+	}
+	return Trigger{XMLName: xml.Name{Local: "EventTrigger"}, Subscription: subscription}, nil
+}
@@ -0,0 +1,92 @@
+package persist
+
+import (
+	"fmt"
+	"strings"
+)
+
+// splitTaskPath splits a task name such as \Acme\Updater\Check into its
+// folder (\Acme\Updater) and task name (Check). Names without a folder are
+// in the root folder \. Forward slashes are accepted as separators.
+func splitTaskPath(name string) (folder, task string, err error) {
+	name = strings.ReplaceAll(name, "/", `\`)
+	parts := strings.Split(strings.Trim(name, `\`), `\`)
+	for _, part := range parts {
+		if part == "" || part == "." || part == ".." {
+			return "", "", fmt.Errorf("invalid task path '%s'", name)
+		}
+	}
+
+	task = parts[len(parts)-1]
+	folder = `\` + strings.Join(parts[:len(parts)-1], `\`)
+	return folder, task, nil
+}
+
+// taskPath returns the full path of a task in a folder.
+func taskPath(folder, task string) string {
+	if folder == `\` {
+		return `\` + task
+	}
+	return folder + `\` + task
+}
+
+// taskFolderPath normalizes a folder path such as Acme/Updater to
+// \Acme\Updater. An empty path is the root folder \.
+func taskFolderPath(folder string) string {
+	folder = strings.ReplaceAll(folder, "/", `\`)
+	return `\` + strings.Trim(folder, `\`)
+}
+
+// taskFolderChain returns the folders from the top level down to folder, e.g.
+// \Acme and \Acme\Updater for \Acme\Updater. The root folder is not included.
+func taskFolderChain(folder string) []string {
+	var chain []string
+	path := ""
+	for _, part := range strings.Split(strings.Trim(folder, `\`), `\`) {
+		if part == "" {
+			continue
+		}
+		path += `\` + part
+		chain = append(chain, path)
+	}
+	return chain
+}
+
+// parentTaskFolder returns the folder containing folder and its own name.
+func parentTaskFolder(folder string) (parent, name string) {
+	i := strings.LastIndex(folder, `\`)
+	parent, name = folder[:i], folder[i+1:]
+	if parent == "" {
+		parent = `\`
+	}
+	return parent, name
+}
+
+// recordTaskFolders adds folders to the list of folders GoPersist created.
+func (st *persistState) recordTaskFolders(folders []string) {
+	for _, folder := range folders {
+		if !st.createdTaskFolder(folder) {
+			st.TaskFolders = append(st.TaskFolders, folder)
+		}
+	}
+}
+
+// createdTaskFolder reports whether GoPersist created folder.
+func (st *persistState) createdTaskFolder(folder string) bool {
+	for _, f := range st.TaskFolders {
+		if strings.EqualFold(f, folder) {
+			return true
+		}
+	}
+	return false
+}
+
+// forgetTaskFolder removes folder from the list of folders GoPersist created.
+func (st *persistState) forgetTaskFolder(folder string) {
+	for i, f := range st.TaskFolders {
+		if strings.EqualFold(f, folder) {
+			st.TaskFolders = append(st.TaskFolders[:i], st.TaskFolders[i+1:]...)
+			return
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package persist
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode/utf16"
+)
+
+// OfflineTask is a scheduled task read from a Tasks directory on disk.
+type OfflineTask struct {
+	// Path is the task path relative to the Tasks directory, e.g. "\Microsoft\Windows\Defrag\ScheduledDefrag".
+	Path       string
+	File       string
+	Definition *TaskDefinition
+}
+
+// InventoryTasks parses every task file below tasksDir, typically the
+// Windows\System32\Tasks directory of a mounted image. Files that cannot be
+// parsed are reported in the returned error list but do not stop the walk.
+func InventoryTasks(tasksDir string) ([]OfflineTask, []error) {
+	var tasks []OfflineTask
+	var errs []error
+
+	err := filepath.WalkDir(tasksDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			errs = append(errs, err)
+			return nil
+		}
+		if d.IsDir() {
+			return nil
+		}
+
+		def, err := ReadTaskFile(path)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to parse task file %s: %w", path, err))
+			return nil
+		}
+
+		rel, err := filepath.Rel(tasksDir, path)
+		if err != nil {
+			rel = filepath.Base(path)
+		}
+		tasks = append(tasks, OfflineTask{
+			Path:       `\` + strings.ReplaceAll(filepath.ToSlash(rel), "/", `\`),
+			File:       path,
+			Definition: def,
+		})
+		return nil
+	})
+	if err != nil {
+		errs = append(errs, err)
+	}
+
+	sort.Slice(tasks, func(i, j int) bool { return tasks[i].Path < tasks[j].Path })
+	return tasks, errs
+}
+
+// ReadTaskFile reads a Task Scheduler XML file from disk.
+func ReadTaskFile(path string) (*TaskDefinition, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseTaskXML(data)
+}
+
+// ParseTaskXML parses a Task Scheduler XML document. Both the UTF-16 files
+// Task Scheduler writes to disk and plain UTF-8 documents are accepted.
+func ParseTaskXML(data []byte) (*TaskDefinition, error) {
+	data = decodeTaskXMLBytes(data)
+
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	// The bytes are UTF-8 by now, whatever the declaration says.
+	dec.CharsetReader = func(charset string, input io.Reader) (io.Reader, error) {
+		return input, nil
+	}
+
+	var def TaskDefinition
+	if err := dec.Decode(&def); err != nil {
+		return nil, fmt.Errorf("failed to decode task XML: %w", err)
+	}
+
+	// Drop the namespace captured on trigger and action elements so that
+	// re-rendering the definition does not repeat it on every element.
+	for i := range def.TriggerList() {
+		def.Triggers.Items[i].XMLName.Space = ""
+	}
+	for i := range def.Actions.Items {
+		def.Actions.Items[i].XMLName.Space = ""
+	}
+	return &def, nil
+}
+
+// decodeTaskXMLBytes converts UTF-16 input (detected by its byte order mark)
+// to UTF-8 and strips a UTF-8 byte order mark.
+func decodeTaskXMLBytes(data []byte) []byte {
+	var order binary.ByteOrder
+	switch {
+	case bytes.HasPrefix(data, []byte{0xFF, 0xFE}):
+		order = binary.LittleEndian
+	case bytes.HasPrefix(data, []byte{0xFE, 0xFF}):
+		order = binary.BigEndian
+	default:
+		return bytes.TrimPrefix(data, []byte{0xEF, 0xBB, 0xBF})
+	}
+
+	data = data[2:]
+	units := make([]uint16, len(data)/2)
+	for i := range units {
+		units[i] = order.Uint16(data[2*i:])
+	}
+	return []byte(string(utf16.Decode(units)))
+}
@@ -0,0 +1,147 @@
+package persist
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"unicode/utf16"
+)
+
+// monthlyTaskXML is a task as Task Scheduler writes it to disk, before
+// encoding as UTF-16LE.
+const monthlyTaskXML = `<?xml version="1.0" encoding="UTF-16"?>
+<Task version="1.2" xmlns="http://schemas.microsoft.com/windows/2004/02/mit/task">
+  <RegistrationInfo>
+    <Author>CONTOSO\admin</Author>
+    <URI>\Maintenance\Monthly Cleanup</URI>
+  </RegistrationInfo>
+  <Triggers>
+    <CalendarTrigger>
+      <StartBoundary>2024-01-01T03:00:00</StartBoundary>
+      <Enabled>true</Enabled>
+      <ScheduleByMonthDayOfWeek>
+        <Weeks>
+          <Week>1</Week>
+          <Week>Last</Week>
+        </Weeks>
+        <DaysOfWeek>
+          <Friday />
+        </DaysOfWeek>
+        <Months>
+          <March />
+          <September />
+        </Months>
+      </ScheduleByMonthDayOfWeek>
+    </CalendarTrigger>
+    <CalendarTrigger>
+      <StartBoundary>2024-01-01T04:00:00</StartBoundary>
+      <ScheduleByDay>
+        <DaysInterval>2</DaysInterval>
+      </ScheduleByDay>
+    </CalendarTrigger>
+  </Triggers>
+  <Principals>
+    <Principal id="Author">
+      <UserId>S-1-5-18</UserId>
+      <RunLevel>HighestAvailable</RunLevel>
+    </Principal>
+  </Principals>
+  <Actions Context="Author">
+    <Exec>
+      <Command>C:\Windows\System32\cleanmgr.exe</Command>
+      <Arguments>/sagerun:1</Arguments>
+    </Exec>
+  </Actions>
+</Task>
+`
+
+// utf16LEWithBOM encodes s the way Task Scheduler stores task files.
+func utf16LEWithBOM(s string) []byte {
+	units := utf16.Encode([]rune(s))
+	data := make([]byte, 2+2*len(units))
+	data[0], data[1] = 0xFF, 0xFE
+	for i, u := range units {
+		binary.LittleEndian.PutUint16(data[2+2*i:], u)
+	}
+	return data
+}
+
+func TestParseTaskXMLUTF16LE(t *testing.T) {
+	def, err := ParseTaskXML(utf16LEWithBOM(monthlyTaskXML))
+	if err != nil {
+		t.Fatalf("ParseTaskXML: %v", err)
+	}
+
+	if got := def.RegistrationInfo.URI; got != `\Maintenance\Monthly Cleanup` {
+		t.Errorf("URI = %q", got)
+	}
+	triggers := def.TriggerList()
+	if len(triggers) != 2 {
+		t.Fatalf("got %d triggers, want 2", len(triggers))
+	}
+
+	s := triggers[0].ScheduleByMonthDayOfWeek
+	if s == nil {
+		t.Fatal("ScheduleByMonthDayOfWeek not parsed")
+	}
+	want := &ScheduleByMonthDayOfWeek{
+		Weeks:      &Weeks{Weeks: []string{"1", "Last"}},
+		DaysOfWeek: &NameList{Names: []string{"Friday"}},
+		Months:     &NameList{Names: []string{"March", "September"}},
+	}
+	if !reflect.DeepEqual(s, want) {
+		t.Errorf("ScheduleByMonthDayOfWeek = %+v, want %+v", s, want)
+	}
+	if d := triggers[1].ScheduleByDay; d == nil || d.DaysInterval != 2 {
+		t.Errorf("ScheduleByDay = %+v, want interval 2", d)
+	}
+
+	actions := def.Actions.Items
+	if len(actions) != 1 || actions[0].Kind() != "Exec" || actions[0].Command != `C:\Windows\System32\cleanmgr.exe` {
+		t.Errorf("actions = %+v", actions)
+	}
+	if p := def.PrincipalList(); len(p) != 1 || p[0].UserID != "S-1-5-18" {
+		t.Errorf("principals = %+v", p)
+	}
+}
+
+func TestParseTaskXMLEncodings(t *testing.T) {
+	plain, err := ParseTaskXML([]byte(monthlyTaskXML))
+	if err != nil {
+		t.Fatalf("UTF-8: %v", err)
+	}
+	bom, err := ParseTaskXML(append([]byte{0xEF, 0xBB, 0xBF}, monthlyTaskXML...))
+	if err != nil {
+		t.Fatalf("UTF-8 with BOM: %v", err)
+	}
+	utf16le, err := ParseTaskXML(utf16LEWithBOM(monthlyTaskXML))
+	if err != nil {
+		t.Fatalf("UTF-16LE: %v", err)
+	}
+	if !reflect.DeepEqual(plain, bom) || !reflect.DeepEqual(plain, utf16le) {
+		t.Error("encodings parse to different definitions")
+	}
+}
+
+func TestInventoryTasks(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "Maintenance"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "Maintenance", "Monthly Cleanup"), utf16LEWithBOM(monthlyTaskXML), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "Broken"), utf16LEWithBOM("<Task><Actions>"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tasks, errs := InventoryTasks(dir)
+	if len(tasks) != 1 || tasks[0].Path != `\Maintenance\Monthly Cleanup` {
+		t.Fatalf("tasks = %+v", tasks)
+	}
+	if len(errs) != 1 {
+		t.Errorf("got %d errors, want 1 for the broken file: %v", len(errs), errs)
+	}
+}
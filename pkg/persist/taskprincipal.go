@@ -0,0 +1,245 @@
+package persist
+
+import (
+	"fmt"
+	"strings"
+)
+
+// TaskLogonType mirrors the Task Scheduler TASK_LOGON_TYPE enumeration.
+type TaskLogonType int
+
+const (
+	TaskLogonNone TaskLogonType = iota
+	TaskLogonPassword
+	TaskLogonS4U
+	TaskLogonInteractiveToken
+	TaskLogonGroup
+	TaskLogonServiceAccount
+	TaskLogonInteractiveTokenOrPassword
+)
+
+// taskLogonTypeNames maps logon types to their name in the task XML.
+var taskLogonTypeNames = map[TaskLogonType]string{
+	TaskLogonPassword:                   "Password",
+	TaskLogonS4U:                        "S4U",
+	TaskLogonInteractiveToken:           "InteractiveToken",
+	TaskLogonServiceAccount:             "ServiceAccount",
+	TaskLogonInteractiveTokenOrPassword: "InteractiveTokenOrPassword",
+}
+
+// serviceAccountSIDs maps the built-in service accounts to their SIDs.
+var serviceAccountSIDs = map[string]string{
+	"system":         "S-1-5-18",
+	"localsystem":    "S-1-5-18",
+	"localservice":   "S-1-5-19",
+	"networkservice": "S-1-5-20",
+}
+
+// TaskPrincipal is the security context a scheduled task runs under.
+type TaskPrincipal struct {
+	// UserID is a user or service account (SYSTEM, LocalService,
+	// NetworkService or DOMAIN\user). Empty means the current user.
+	UserID string
+	// GroupID runs the task for every member of a group instead of a user.
+	GroupID   string
+	Password  string
+	LogonType TaskLogonType
+	// Highest runs the task with the highest privileges available
+	// instead of as a limited (LUA) user.
+	Highest bool
+}
+
+// NewTaskPrincipal validates and builds a principal from command line values.
+// logon is one of password, s4u or interactive and runLevel one of lua or
+// highest; both may be empty to use a sensible default.
+func NewTaskPrincipal(user, group, password, logon, runLevel string) (*TaskPrincipal, error) {
+	p := &TaskPrincipal{UserID: user, GroupID: group, Password: password}
+
+	switch strings.ToLower(runLevel) {
+	case "", "lua", "limited":
+	case "highest":
+		p.Highest = true
+	default:
+		return nil, fmt.Errorf("unknown run level %q, expected lua or highest", runLevel)
+	}
+
+	switch {
+	case user != "" && group != "":
+		return nil, fmt.Errorf("a task principal is either a user or a group, not both")
+	case group != "":
+		if logon != "" || password != "" {
+			return nil, fmt.Errorf("a group principal does not take a logon type or password")
+		}
+		p.LogonType = TaskLogonGroup
+		return p, nil
+	case p.IsServiceAccount():
+		if logon != "" || password != "" {
+			return nil, fmt.Errorf("service account %s does not take a logon type or password", user)
+		}
+		p.LogonType = TaskLogonServiceAccount
+		return p, nil
+	}
+
+	switch strings.ToLower(logon) {
+	case "":
+		p.LogonType = TaskLogonInteractiveToken
+		if password != "" {
+			p.LogonType = TaskLogonPassword
+		}
+	case "password":
+		p.LogonType = TaskLogonPassword
+	case "s4u":
+		p.LogonType = TaskLogonS4U
+	case "interactive":
+		p.LogonType = TaskLogonInteractiveToken
+	case "interactive-or-password":
+		p.LogonType = TaskLogonInteractiveTokenOrPassword
+	default:
+		return nil, fmt.Errorf("unknown logon type %q, expected password, s4u, interactive or interactive-or-password", logon)
+	}
+
+	if p.LogonType == TaskLogonPassword && (user == "" || password == "") {
+		return nil, fmt.Errorf("password logon requires both a user and a password")
+	}
+	if p.LogonType == TaskLogonS4U && password != "" {
+		return nil, fmt.Errorf("S4U logon does not take a password")
+	}
+	return p, nil
+}
+
+// principalFromXML converts the principal of an imported task definition.
+func principalFromXML(x Principal) *TaskPrincipal {
+	p := &TaskPrincipal{UserID: x.UserID, GroupID: x.GroupID, Highest: x.RunLevel == "HighestAvailable"}
+	switch {
+	case x.GroupID != "":
+		p.LogonType = TaskLogonGroup
+	case p.IsServiceAccount():
+		p.LogonType = TaskLogonServiceAccount
+	default:
+		p.LogonType = TaskLogonInteractiveToken
+		for logonType, name := range taskLogonTypeNames {
+			if name == x.LogonType {
+				p.LogonType = logonType
+			}
+		}
+	}
+	return p
+}
+
+// checkPassword rejects a password logon without the password, as for a
+// principal imported from task XML, which never contains the password.
+func (p *TaskPrincipal) checkPassword() error {
+	if p != nil && p.LogonType == TaskLogonPassword && p.Password == "" {
+		return fmt.Errorf("principal %s logs on with a password, which task XML does not contain: give it with SetPrincipal (-sch-user and -sch-password)", p.UserID)
+	}
+	return nil
+}
+
+// IsServiceAccount reports whether the principal is SYSTEM, LocalService or
+// NetworkService.
+func (p *TaskPrincipal) IsServiceAccount() bool {
+	if _, ok := serviceAccountSIDs[p.accountKey()]; ok {
+		return true
+	}
+	for _, sid := range serviceAccountSIDs {
+		if strings.EqualFold(p.UserID, sid) {
+			return true
+		}
+	}
+	return false
+}
+
+// accountKey normalises the user name for looking up service accounts, so
+// that "NT AUTHORITY\SYSTEM" and "system" match.
+func (p *TaskPrincipal) accountKey() string {
+	name := strings.ToLower(p.UserID)
+	name = strings.TrimPrefix(name, `nt authority\`)
+	return strings.ReplaceAll(name, " ", "")
+}
+
+// xml returns the principal as it appears in the task XML.
+func (p *TaskPrincipal) xml() Principal {
+	x := Principal{ID: "Author", RunLevel: "LeastPrivilege"}
+	if p.Highest {
+		x.RunLevel = "HighestAvailable"
+	}
+
+	switch {
+	case p.LogonType == TaskLogonGroup:
+		x.GroupID = p.GroupID
+	case p.IsServiceAccount():
+		x.UserID = p.UserID
+		if sid, ok := serviceAccountSIDs[p.accountKey()]; ok {
+			x.UserID = sid
+		}
+		x.LogonType = taskLogonTypeNames[TaskLogonServiceAccount]
+	default:
+		x.UserID = p.UserID
+		x.LogonType = taskLogonTypeNames[p.LogonType]
+	}
+	return x
+}
+
+// registrationArgs returns the user, password and logon type arguments of
+// RegisterTask. Empty strings are passed as nil.
+func (p *TaskPrincipal) registrationArgs() (user, password interface{}, logonType TaskLogonType) {
+	if p == nil {
+		// Run with the interactive token of the current user.
+		return nil, nil, TaskLogonInteractiveToken
+	}
+
+	switch {
+	case p.LogonType == TaskLogonGroup:
+		user = p.GroupID
+	case p.IsServiceAccount():
+		user = p.xml().UserID
+	case p.UserID != "":
+		user = p.UserID
+	}
+	if p.Password != "" {
+		password = p.Password
+	}
+	return user, password, p.LogonType
+}
+
+// needsElevation reports whether registering a task for the principal
+// requires an elevated administrator.
+func (p *TaskPrincipal) needsElevation(currentUser string) bool {
+	if p == nil {
+		return false
+	}
+	if p.Highest || p.LogonType == TaskLogonGroup || p.IsServiceAccount() {
+		return true
+	}
+	return p.UserID != "" && !sameAccount(p.UserID, currentUser)
+}
+
+// describePrincipal names the account and run level of a principal for
+// error messages. A nil principal is the current user.
+func describePrincipal(p *TaskPrincipal) string {
+	if p == nil {
+		return "the current user"
+	}
+	name := p.UserID
+	if p.LogonType == TaskLogonGroup {
+		name = "group " + p.GroupID
+	}
+	if name == "" {
+		name = "the current user"
+	}
+	if p.Highest {
+		name += " with highest privileges"
+	}
+	return name
+}
+
+// sameAccount compares two account names, ignoring case and allowing one of
+// them to omit the domain.
+func sameAccount(a, b string) bool {
+	if strings.EqualFold(a, b) {
+		return true
+	}
+	_, shortA, okA := strings.Cut(a, `\`)
+	_, shortB, okB := strings.Cut(b, `\`)
+	return (okA && !okB && strings.EqualFold(shortA, b)) || (okB && !okA && strings.EqualFold(a, shortB))
+}
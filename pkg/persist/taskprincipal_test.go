@@ -0,0 +1,40 @@
+package persist
+
+import "testing"
+
+func TestDescribePrincipal(t *testing.T) {
+	tests := []struct {
+		p    *TaskPrincipal
+		want string
+	}{
+		{nil, "the current user"},
+		{&TaskPrincipal{}, "the current user"},
+		{&TaskPrincipal{UserID: `CONTOSO\bob`, Highest: true}, `CONTOSO\bob with highest privileges`},
+		{&TaskPrincipal{GroupID: `BUILTIN\Users`, LogonType: TaskLogonGroup}, `group BUILTIN\Users`},
+	}
+	for _, tt := range tests {
+		if got := describePrincipal(tt.p); got != tt.want {
+			t.Errorf("describePrincipal(%+v) = %q, want %q", tt.p, got, tt.want)
+		}
+	}
+}
+
+func TestNeedsElevation(t *testing.T) {
+	tests := []struct {
+		p    *TaskPrincipal
+		want bool
+	}{
+		// No principal flags and no imported principal: the current user
+		{nil, false},
+		{&TaskPrincipal{UserID: `CONTOSO\bob`, LogonType: TaskLogonInteractiveToken}, false},
+		{&TaskPrincipal{UserID: "bob", LogonType: TaskLogonInteractiveToken}, false},
+		{&TaskPrincipal{UserID: `CONTOSO\alice`, LogonType: TaskLogonPassword}, true},
+		{&TaskPrincipal{UserID: "SYSTEM", LogonType: TaskLogonServiceAccount}, true},
+		{&TaskPrincipal{Highest: true}, true},
+	}
+	for _, tt := range tests {
+		if got := tt.p.needsElevation(`CONTOSO\bob`); got != tt.want {
+			t.Errorf("needsElevation(%+v) = %v, want %v", tt.p, got, tt.want)
+		}
+	}
+}
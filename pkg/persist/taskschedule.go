@@ -0,0 +1,391 @@
+package persist
+
+import (
+	"encoding/xml"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxTaskTriggers is the number of triggers Task Scheduler allows per task.
+const maxTaskTriggers = 48
+
+// cronMacros maps the cron @ shortcuts to the expressions they stand for.
+var cronMacros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+var cronMonthNames = map[string]int{
+	"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+	"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+}
+
+var cronDayNames = map[string]int{
+	"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+}
+
+// timeSlot is a time of day at which a trigger starts, optionally repeated
+// every interval for duration.
+type timeSlot struct {
+	start    time.Duration
+	interval time.Duration
+	duration time.Duration
+}
+
+// isScheduleExpression reports whether a trigger spec is a cron expression or
+// an "every ..." schedule rather than a named trigger.
+func isScheduleExpression(spec string) bool {
+	fields := strings.Fields(spec)
+	if len(fields) == 0 {
+		return false
+	}
+	if strings.HasPrefix(fields[0], "@") || strings.EqualFold(fields[0], "every") {
+		return true
+	}
+	if len(fields) != 5 {
+		return false
+	}
+	for _, field := range fields {
+		if strings.Contains(field, "=") {
+			return false
+		}
+	}
+	return true
+}
+
+// ParseSchedule translates a cron expression ("*/15 9-17 * * 1-5", "@daily",
+// "@reboot") or an "every" schedule ("every 15m between 09:00-17:00 on
+// weekdays") into Task Scheduler triggers. Expressions that Task Scheduler
+// cannot represent are rejected.
+func ParseSchedule(spec string, now time.Time) ([]Trigger, error) {
+	spec = strings.TrimSpace(spec)
+	if strings.EqualFold(spec, "@reboot") {
+		return []Trigger{{XMLName: xml.Name{Local: "BootTrigger"}}}, nil
+	}
+	if expr, ok := cronMacros[strings.ToLower(spec)]; ok {
+		spec = expr
+	}
+
+	fields := strings.Fields(spec)
+	if len(fields) > 0 && strings.EqualFold(fields[0], "every") {
+		return parseEverySchedule(fields[1:], now)
+	}
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("cron expression must have 5 fields (minute hour day-of-month month day-of-week), got %d", len(fields))
+	}
+	return parseCron(fields, now)
+}
+
+// parseCron translates the five fields of a cron expression into triggers.
+func parseCron(fields []string, now time.Time) ([]Trigger, error) {
+	minutes, _, err := parseCronField(fields[0], 0, 59, nil)
+	if err != nil {
+		return nil, fmt.Errorf("invalid minute field: %w", err)
+	}
+	hours, _, err := parseCronField(fields[1], 0, 23, nil)
+	if err != nil {
+		return nil, fmt.Errorf("invalid hour field: %w", err)
+	}
+	days, anyDay, err := parseCronField(fields[2], 1, 31, nil)
+	if err != nil {
+		return nil, fmt.Errorf("invalid day-of-month field: %w", err)
+	}
+	months, anyMonth, err := parseCronField(fields[3], 1, 12, cronMonthNames)
+	if err != nil {
+		return nil, fmt.Errorf("invalid month field: %w", err)
+	}
+	weekdays, anyWeekday, err := parseCronField(fields[4], 0, 7, cronDayNames)
+	if err != nil {
+		return nil, fmt.Errorf("invalid day-of-week field: %w", err)
+	}
+
+	var monthList []string
+	for _, m := range months {
+		monthList = append(monthList, monthNames[m-1])
+	}
+	var dayList []string
+	for _, d := range days {
+		dayList = append(dayList, strconv.Itoa(d))
+	}
+	var weekdayList []string
+	seen := map[int]bool{}
+	for _, d := range weekdays {
+		// Both 0 and 7 mean Sunday.
+		d %= 7
+		if !seen[d] {
+			seen[d] = true
+			weekdayList = append(weekdayList, weekdayNames[d])
+		}
+	}
+
+	// Work out the calendar schedules the times of day apply to.
+	var bases []Trigger
+	switch {
+	case anyDay && anyWeekday && anyMonth:
+		bases = append(bases, Trigger{ScheduleByDay: &ScheduleByDay{DaysInterval: 1}})
+	case anyDay && anyWeekday:
+		var all []string
+		for d := 1; d <= 31; d++ {
+			all = append(all, strconv.Itoa(d))
+		}
+		bases = append(bases, Trigger{ScheduleByMonth: &ScheduleByMonth{DaysOfMonth: &DaysOfMonth{Days: all}, Months: &NameList{Names: monthList}}})
+	case anyDay && anyMonth:
+		bases = append(bases, Trigger{ScheduleByWeek: &ScheduleByWeek{WeeksInterval: 1, DaysOfWeek: &NameList{Names: weekdayList}}})
+	case anyDay:
+		bases = append(bases, Trigger{ScheduleByMonthDayOfWeek: everyWeekOfMonth(weekdayList, monthList)})
+	case anyWeekday:
+		bases = append(bases, Trigger{ScheduleByMonth: &ScheduleByMonth{DaysOfMonth: &DaysOfMonth{Days: dayList}, Months: &NameList{Names: monthList}}})
+	default:
+		// Cron runs when either the day of month or the day of week matches.
+		byWeekday := Trigger{ScheduleByWeek: &ScheduleByWeek{WeeksInterval: 1, DaysOfWeek: &NameList{Names: weekdayList}}}
+		if !anyMonth {
+			byWeekday = Trigger{ScheduleByMonthDayOfWeek: everyWeekOfMonth(weekdayList, monthList)}
+		}
+		bases = append(bases,
+			Trigger{ScheduleByMonth: &ScheduleByMonth{DaysOfMonth: &DaysOfMonth{Days: dayList}, Months: &NameList{Names: monthList}}},
+			byWeekday,
+		)
+	}
+
+	var times []time.Duration
+	for _, h := range hours {
+		for _, m := range minutes {
+			times = append(times, time.Duration(h)*time.Hour+time.Duration(m)*time.Minute)
+		}
+	}
+	return expandTimeSlots(bases, timeSlotsFor(times), now)
+}
+
+// parseEverySchedule parses the words following "every" in a schedule such
+// as "every 15m between 09:00-17:00 on weekdays".
+func parseEverySchedule(words []string, now time.Time) ([]Trigger, error) {
+	if len(words) == 0 {
+		return nil, fmt.Errorf("every requires an interval, e.g. every 15m")
+	}
+	interval, err := time.ParseDuration(words[0])
+	if err != nil || interval < time.Minute || interval >= 24*time.Hour || interval%time.Minute != 0 {
+		return nil, fmt.Errorf("interval must be whole minutes between 1m and 24h, got %q", words[0])
+	}
+
+	slot := timeSlot{interval: interval, duration: 24 * time.Hour}
+	base := Trigger{ScheduleByDay: &ScheduleByDay{DaysInterval: 1}}
+	words = words[1:]
+	for len(words) > 0 {
+		if len(words) < 2 {
+			return nil, fmt.Errorf("missing value after %q", words[0])
+		}
+		switch strings.ToLower(words[0]) {
+		case "between":
+			from, to, ok := strings.Cut(words[1], "-")
+			if !ok {
+				return nil, fmt.Errorf("between expects HH:MM-HH:MM, got %q", words[1])
+			}
+			start, err := time.Parse("15:04", from)
+			if err != nil {
+				return nil, fmt.Errorf("between expects HH:MM-HH:MM, got %q", words[1])
+			}
+			end, err := time.Parse("15:04", to)
+			if err != nil || !end.After(start) {
+				return nil, fmt.Errorf("between expects HH:MM-HH:MM with the end after the start, got %q", words[1])
+			}
+			slot.start = time.Duration(start.Hour())*time.Hour + time.Duration(start.Minute())*time.Minute
+			slot.duration = end.Sub(start)
+			if slot.duration < slot.interval {
+				return nil, fmt.Errorf("the window %s is shorter than the interval %s", words[1], words[0])
+			}
+
+		case "on":
+			var days []string
+			switch strings.ToLower(words[1]) {
+			case "weekdays":
+				days = weekdayNames[1:6]
+			case "weekends":
+				days = []string{weekdayNames[6], weekdayNames[0]}
+			default:
+				days, err = parseNames(words[1], weekdayNames, "")
+				if err != nil {
+					return nil, err
+				}
+			}
+			base = Trigger{ScheduleByWeek: &ScheduleByWeek{WeeksInterval: 1, DaysOfWeek: &NameList{Names: days}}}
+
+		default:
+			return nil, fmt.Errorf("unexpected %q, expected between or on", words[0])
+		}
+		words = words[2:]
+	}
+
+	return expandTimeSlots([]Trigger{base}, []timeSlot{slot}, now)
+}
+
+// timeSlotsFor groups sorted times of day into time slots. Evenly spaced
+// times become a single repeating slot. Otherwise the times are grouped by
+// their minute past the hour, so "0,10,45 * * * *" becomes three hourly
+// slots; times of a group that are not evenly spaced get a slot each.
+func timeSlotsFor(times []time.Duration) []timeSlot {
+	sort.Slice(times, func(i, j int) bool { return times[i] < times[j] })
+	if slot, ok := evenTimeSlot(times); ok {
+		return []timeSlot{slot}
+	}
+
+	var offsets []time.Duration
+	groups := map[time.Duration][]time.Duration{}
+	for _, t := range times {
+		offset := t % time.Hour
+		if _, ok := groups[offset]; !ok {
+			offsets = append(offsets, offset)
+		}
+		groups[offset] = append(groups[offset], t)
+	}
+	sort.Slice(offsets, func(i, j int) bool { return groups[offsets[i]][0] < groups[offsets[j]][0] })
+
+	var slots []timeSlot
+	for _, offset := range offsets {
+		if slot, ok := evenTimeSlot(groups[offset]); ok {
+			slots = append(slots, slot)
+			continue
+		}
+		for _, t := range groups[offset] {
+			slots = append(slots, timeSlot{start: t})
+		}
+	}
+	return slots
+}
+
+// evenTimeSlot returns a single slot repeating through the sorted times, if
+// they are evenly spaced.
+func evenTimeSlot(times []time.Duration) (timeSlot, bool) {
+	if len(times) == 1 {
+		return timeSlot{start: times[0]}, true
+	}
+	interval := times[1] - times[0]
+	for i := 2; i < len(times); i++ {
+		if times[i]-times[i-1] != interval {
+			return timeSlot{}, false
+		}
+	}
+	// The pattern stops repeating once duration has elapsed, so cover
+	// exactly len(times) intervals.
+	return timeSlot{start: times[0], interval: interval, duration: time.Duration(len(times)) * interval}, true
+}
+
+// everyWeekOfMonth returns a schedule running on days in every week of
+// months. The fourth and last week overlap in most months, but a day only
+// runs once.
+func everyWeekOfMonth(days, months []string) *ScheduleByMonthDayOfWeek {
+	return &ScheduleByMonthDayOfWeek{
+		Weeks:      &Weeks{Weeks: []string{"1", "2", "3", "4", "Last"}},
+		DaysOfWeek: &NameList{Names: days},
+		Months:     &NameList{Names: months},
+	}
+}
+
+// expandTimeSlots creates a CalendarTrigger for every combination of
+// calendar schedule and time slot.
+func expandTimeSlots(bases []Trigger, slots []timeSlot, now time.Time) ([]Trigger, error) {
+	if len(bases)*len(slots) > maxTaskTriggers {
+		return nil, fmt.Errorf("the schedule needs %d triggers but Task Scheduler allows at most %d", len(bases)*len(slots), maxTaskTriggers)
+	}
+
+	y, m, d := now.Date()
+	midnight := time.Date(y, m, d, 0, 0, 0, 0, now.Location())
+
+	var triggers []Trigger
+	for _, base := range bases {
+		for _, slot := range slots {
+			t := base
+			t.XMLName = xml.Name{Local: "CalendarTrigger"}
+			t.StartBoundary = midnight.Add(slot.start).Format(taskTimeLayout)
+			if slot.interval > 0 {
+				t.Repetition = &Repetition{
+					Interval: formatTaskDuration(slot.interval),
+					Duration: formatTaskDuration(slot.duration),
+				}
+			}
+			triggers = append(triggers, t)
+		}
+	}
+	return triggers, nil
+}
+
+// parseCronField expands a cron field (*, */n, a, a-b, a-b/n and comma
+// separated lists of those) into its sorted values. The boolean result
+// reports whether the field was an unrestricted *.
+func parseCronField(field string, min, max int, names map[string]int) ([]int, bool, error) {
+	if field == "*" {
+		return cronRange(min, max, 1), true, nil
+	}
+
+	set := map[int]bool{}
+	for _, part := range strings.Split(field, ",") {
+		rangePart, stepPart, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			n, err := strconv.Atoi(stepPart)
+			if err != nil || n < 1 {
+				return nil, false, fmt.Errorf("invalid step %q", stepPart)
+			}
+			step = n
+		}
+
+		lo, hi := min, max
+		if rangePart != "*" {
+			from, to, isRange := strings.Cut(rangePart, "-")
+			var err error
+			lo, err = cronValue(from, min, max, names)
+			if err != nil {
+				return nil, false, err
+			}
+			hi = lo
+			if isRange {
+				hi, err = cronValue(to, min, max, names)
+				if err != nil {
+					return nil, false, err
+				}
+				if hi < lo {
+					return nil, false, fmt.Errorf("range %q is reversed", rangePart)
+				}
+			} else if hasStep {
+				hi = max
+			}
+		}
+		for _, v := range cronRange(lo, hi, step) {
+			set[v] = true
+		}
+	}
+
+	var values []int
+	for v := range set {
+		values = append(values, v)
+	}
+	sort.Ints(values)
+	return values, false, nil
+}
+
+// cronValue parses a single number or name of a cron field.
+func cronValue(value string, min, max int, names map[string]int) (int, error) {
+	if n, ok := names[strings.ToLower(value)]; ok {
+		return n, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < min || n > max {
+		return 0, fmt.Errorf("value %q out of range %d-%d", value, min, max)
+	}
+	return n, nil
+}
+
+func cronRange(lo, hi, step int) []int {
+	var values []int
+	for v := lo; v <= hi; v += step {
+		values = append(values, v)
+	}
+	return values
+}
@@ -0,0 +1,75 @@
+package persist
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseScheduleMonthDayOfWeek(t *testing.T) {
+	now := time.Date(2026, 3, 2, 12, 0, 0, 0, time.UTC)
+	weeks := &Weeks{Weeks: []string{"1", "2", "3", "4", "Last"}}
+
+	triggers, err := ParseSchedule("0 9 * mar,sep fri", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(triggers) != 1 {
+		t.Fatalf("got %d triggers, want 1", len(triggers))
+	}
+	want := &ScheduleByMonthDayOfWeek{
+		Weeks:      weeks,
+		DaysOfWeek: &NameList{Names: []string{"Friday"}},
+		Months:     &NameList{Names: []string{"March", "September"}},
+	}
+	if got := triggers[0].ScheduleByMonthDayOfWeek; !reflect.DeepEqual(got, want) {
+		t.Errorf("ScheduleByMonthDayOfWeek = %+v, want %+v", got, want)
+	}
+
+	// Day of month or day of week, both within the months
+	triggers, err = ParseSchedule("0 9 15 jan mon", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(triggers) != 2 || triggers[0].ScheduleByMonth == nil || triggers[1].ScheduleByMonthDayOfWeek == nil {
+		t.Fatalf("triggers = %+v, want a monthly and a monthly day-of-week trigger", triggers)
+	}
+	if got := triggers[1].ScheduleByMonthDayOfWeek.Months.Names; !reflect.DeepEqual(got, []string{"January"}) {
+		t.Errorf("months = %v", got)
+	}
+}
+
+func TestParseScheduleTimeSlots(t *testing.T) {
+	now := time.Date(2026, 3, 2, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		spec     string
+		want     []string
+		every    string
+		duration string
+	}{
+		{"*/15 9-17 * * *", []string{"2026-03-02T09:00:00"}, "PT15M", "PT9H"},
+		{"0,10,45 * * * *", []string{"2026-03-02T00:00:00", "2026-03-02T00:10:00", "2026-03-02T00:45:00"}, "PT1H", "P1D"},
+		{"30 8,12,13 * * *", []string{"2026-03-02T08:30:00", "2026-03-02T12:30:00", "2026-03-02T13:30:00"}, "", ""},
+	}
+	for _, tt := range tests {
+		triggers, err := ParseSchedule(tt.spec, now)
+		if err != nil {
+			t.Errorf("ParseSchedule(%q): %v", tt.spec, err)
+			continue
+		}
+		var starts []string
+		for _, tr := range triggers {
+			starts = append(starts, tr.StartBoundary)
+			interval, length := "", ""
+			if tr.Repetition != nil {
+				interval, length = tr.Repetition.Interval, tr.Repetition.Duration
+			}
+			if interval != tt.every || length != tt.duration {
+				t.Errorf("ParseSchedule(%q) repetition = %s for %s, want %s for %s", tt.spec, interval, length, tt.every, tt.duration)
+			}
+		}
+		if !reflect.DeepEqual(starts, tt.want) {
+			t.Errorf("ParseSchedule(%q) starts = %v, want %v", tt.spec, starts, tt.want)
+		}
+	}
+}
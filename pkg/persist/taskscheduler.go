@@ -0,0 +1,363 @@
+//go:build windows
+
+package persist
+
+import (
+	"errors"
+	"fmt"
+	"github.com/go-ole/go-ole"
+	"github.com/go-ole/go-ole/oleutil"
+	"runtime"
+	"sync"
+)
+
+// ErrTaskSchedulerClosed is returned by calls on a closed TaskScheduler.
+var ErrTaskSchedulerClosed = errors.New("task scheduler session is closed")
+
+// TaskScheduler is a session with the Task Scheduler service.
+//
+// COM objects belong to the apartment of the thread that created them, and a
+// goroutine may move between OS threads at any time. The session therefore
+// owns a locked OS thread that initializes COM, connects to Task Scheduler
+// once and runs every call. Its methods may be used from any goroutine; calls
+// are serialized on that thread.
+type TaskScheduler struct {
+	calls   chan func(service *ole.IDispatch)
+	closing chan struct{}
+	closed  chan struct{}
+	once    sync.Once
+}
+
+// NewTaskScheduler starts a session and connects to Task Scheduler on the
+// local computer. Close the session when done.
+func NewTaskScheduler() (*TaskScheduler, error) {
+	ts := &TaskScheduler{
+		calls:   make(chan func(service *ole.IDispatch)),
+		closing: make(chan struct{}),
+		closed:  make(chan struct{}),
+	}
+
+	ready := make(chan error, 1)
+	go ts.run(ready)
+	if err := <-ready; err != nil {
+		return nil, err
+	}
+	return ts, nil
+}
+
+// Close disconnects from Task Scheduler and stops the session thread once
+// the running call, if any, has returned.
+func (ts *TaskScheduler) Close() {
+	ts.once.Do(func() { close(ts.closing) })
+	<-ts.closed
+}
+
+// run is the session thread. It reports on ready whether it connected.
+func (ts *TaskScheduler) run(ready chan<- error) {
+	defer close(ts.closed)
+
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	// Initialize COM in a single-threaded apartment on this thread
+	if err := ole.CoInitializeEx(0, ole.COINIT_APARTMENTTHREADED); err != nil {
+		var oleErr *ole.OleError
+		if !errors.As(err, &oleErr) || oleErr.Code() != 1 { // 1 = S_FALSE, already initialized
+			ready <- fmt.Errorf("failed to initialize COM: %w", err)
+			return
+		}
+	}
+	defer ole.CoUninitialize()
+
+	service, err := connectTaskService()
+	if err != nil {
+		ready <- err
+		return
+	}
+	defer service.Release()
+	ready <- nil
+
+	for {
+		select {
+		case call := <-ts.calls:
+			call(service)
+		case <-ts.closing:
+			return
+		}
+	}
+}
+
+// do runs fn on the session thread and returns its error.
+func (ts *TaskScheduler) do(fn func(service *ole.IDispatch) error) error {
+	result := make(chan error, 1)
+	call := func(service *ole.IDispatch) { result <- fn(service) }
+
+	select {
+	case ts.calls <- call:
+		return <-result
+	case <-ts.closing:
+		return ErrTaskSchedulerClosed
+	}
+}
+
+// connectTaskService creates the Task Scheduler service object and connects
+// it to the local computer.
+func connectTaskService() (*ole.IDispatch, error) {
+	unknown, err := oleutil.CreateObject("Schedule.Service")
+	if err != nil {
+		return nil, taskError("create Task Scheduler object", err)
+	}
+	defer unknown.Release()
+
+	service, err := unknown.QueryInterface(ole.IID_IDispatch)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query Task Scheduler interface: %w", err)
+	}
+
+	_, err = oleutil.CallMethod(service, "Connect")
+	if err != nil {
+		service.Release()
+		return nil, taskError("connect to Task Scheduler", err)
+	}
+	return service, nil
+}
+
+// Register registers taskXML as the task at path, replacing an existing
+// task, and creates missing folders. Created folders are recorded in the
+// state file so that Delete can remove them.
+func (ts *TaskScheduler) Register(path, taskXML string, principal *TaskPrincipal) error {
+	folderPath, name, err := splitTaskPath(path)
+	if err != nil {
+		return err
+	}
+
+	return ts.do(func(service *ole.IDispatch) error {
+		// Open the task folder, creating missing folders
+		taskFolder, created, err := ensureTaskFolder(service, folderPath)
+		if len(created) > 0 {
+			// Record the folders we created so that remove can delete them
+			st, stateErr := loadState()
+			if stateErr != nil {
+				return stateErr
+			}
+			st.recordTaskFolders(created)
+			if stateErr := st.save(); stateErr != nil {
+				return stateErr
+			}
+		}
+		if err != nil {
+			return err
+		}
+		defer taskFolder.Release()
+
+		// Register Task
+		user, password, logonType := principal.registrationArgs()
+		registered, err := oleutil.CallMethod(taskFolder, "RegisterTask", name, taskXML, 6, user, password, int(logonType)) // 6 = create or update the task
+		if err != nil {
+			return taskError("register task "+path, err)
+		}
+		registered.ToIDispatch().Release()
+		return nil
+	})
+}
+
+// Delete deletes the task at path. If removeFolders is set, the folders of
+// the task that GoPersist created are deleted too once empty.
+func (ts *TaskScheduler) Delete(path string, removeFolders bool) error {
+	folderPath, name, err := splitTaskPath(path)
+	if err != nil {
+		return err
+	}
+
+	return ts.do(func(service *ole.IDispatch) error {
+		taskFolder, err := oleutil.CallMethod(service, "GetFolder", folderPath)
+		if err != nil {
+			return taskError("open task folder "+folderPath, err)
+		}
+		defer taskFolder.ToIDispatch().Release()
+
+		_, err = oleutil.CallMethod(taskFolder.ToIDispatch(), "DeleteTask", name, 0)
+		if err != nil {
+			return taskError("delete task "+path, err)
+		}
+
+		if removeFolders {
+			return removeCreatedTaskFolders(service, folderPath)
+		}
+		return nil
+	})
+}
+
+// Exists reports whether a task is registered at path.
+func (ts *TaskScheduler) Exists(path string) (bool, error) {
+	err := ts.withTask(path, func(task *ole.IDispatch) error {
+		return nil
+	})
+	if errors.Is(err, ErrTaskNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Run starts the task at path immediately. params are passed to its actions
+// as $(Arg0), $(Arg1), ...
+func (ts *TaskScheduler) Run(path string, params []string) error {
+	return ts.withTask(path, func(task *ole.IDispatch) error {
+		var args interface{}
+		if len(params) > 0 {
+			args = params
+		}
+		running, err := oleutil.CallMethod(task, "Run", args)
+		if err != nil {
+			return taskError("run task "+path, err)
+		}
+		running.ToIDispatch().Release()
+		return nil
+	})
+}
+
+// Stop stops all running instances of the task at path.
+func (ts *TaskScheduler) Stop(path string) error {
+	return ts.withTask(path, func(task *ole.IDispatch) error {
+		_, err := oleutil.CallMethod(task, "Stop", 0)
+		if err != nil {
+			return taskError("stop task "+path, err)
+		}
+		return nil
+	})
+}
+
+// SetEnabled enables or disables the task at path.
+func (ts *TaskScheduler) SetEnabled(path string, enabled bool) error {
+	return ts.withTask(path, func(task *ole.IDispatch) error {
+		_, err := oleutil.PutProperty(task, "Enabled", enabled)
+		if err != nil {
+			return taskError("update task "+path, err)
+		}
+		return nil
+	})
+}
+
+// Status returns the state, last and next run of the task at path.
+func (ts *TaskScheduler) Status(path string) (*TaskStatus, error) {
+	var status *TaskStatus
+	err := ts.withTask(path, func(task *ole.IDispatch) error {
+		var err error
+		status, err = readTaskStatus(task)
+		return err
+	})
+	return status, err
+}
+
+// List returns the status of every task in folder, including hidden tasks,
+// and with recursive set of every task in its subfolders too.
+func (ts *TaskScheduler) List(folder string, recursive bool) ([]*TaskStatus, error) {
+	var statuses []*TaskStatus
+	err := ts.do(func(service *ole.IDispatch) error {
+		return listTaskFolder(service, taskFolderPath(folder), recursive, &statuses)
+	})
+	return statuses, err
+}
+
+// withTask opens the registered task at path on the session thread and calls
+// fn with it.
+func (ts *TaskScheduler) withTask(path string, fn func(task *ole.IDispatch) error) error {
+	folderPath, name, err := splitTaskPath(path)
+	if err != nil {
+		return err
+	}
+
+	return ts.do(func(service *ole.IDispatch) error {
+		taskFolder, err := oleutil.CallMethod(service, "GetFolder", folderPath)
+		if err != nil {
+			return taskError("open task folder "+folderPath, err)
+		}
+		defer taskFolder.ToIDispatch().Release()
+
+		task, err := oleutil.CallMethod(taskFolder.ToIDispatch(), "GetTask", name)
+		if err != nil {
+			return taskError("open task "+path, err)
+		}
+		defer task.ToIDispatch().Release()
+
+		return fn(task.ToIDispatch())
+	})
+}
+
+// listTaskFolder appends the status of the tasks in the folder at path to
+// statuses, descending into subfolders if recursive is set.
+func listTaskFolder(service *ole.IDispatch, path string, recursive bool, statuses *[]*TaskStatus) error {
+	folder, err := oleutil.CallMethod(service, "GetFolder", path)
+	if err != nil {
+		return taskError("open task folder "+path, err)
+	}
+	defer folder.ToIDispatch().Release()
+
+	tasks, err := oleutil.CallMethod(folder.ToIDispatch(), "GetTasks", 1) // 1 = include hidden tasks
+	if err != nil {
+		return taskError("list tasks in "+path, err)
+	}
+	defer tasks.ToIDispatch().Release()
+
+	err = oleutil.ForEach(tasks.ToIDispatch(), func(item *ole.VARIANT) error {
+		defer item.Clear()
+		status, err := readTaskStatus(item.ToIDispatch())
+		if err != nil {
+			return err
+		}
+		*statuses = append(*statuses, status)
+		return nil
+	})
+	if err != nil || !recursive {
+		return err
+	}
+
+	subfolders, err := oleutil.CallMethod(folder.ToIDispatch(), "GetFolders", 0)
+	if err != nil {
+		return taskError("list folders in "+path, err)
+	}
+	defer subfolders.ToIDispatch().Release()
+
+	var paths []string
+	err = oleutil.ForEach(subfolders.ToIDispatch(), func(item *ole.VARIANT) error {
+		defer item.Clear()
+		subPath, err := oleutil.GetProperty(item.ToIDispatch(), "Path")
+		if err != nil {
+			return err
+		}
+		paths = append(paths, subPath.ToString())
+		subPath.Clear()
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, subPath := range paths {
+		if err := listTaskFolder(service, subPath, true, statuses); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// taskError decodes a COM error that Task Scheduler returned for op. The
+// HRESULT of an exception raised by a method is in its EXCEPINFO rather than
+// the error code, which is just DISP_E_EXCEPTION. Other errors are wrapped
+// as they are.
+func taskError(op string, err error) error {
+	var oleErr *ole.OleError
+	if !errors.As(err, &oleErr) {
+		return fmt.Errorf("failed to %s: %w", op, err)
+	}
+
+	code := uint32(oleErr.Code())
+	if info, ok := oleErr.SubError().(ole.EXCEPINFO); ok && info.SCODE() != 0 {
+		code = info.SCODE()
+	}
+	description := oleErr.Description()
+	if description == "<nil>" {
+		description = ""
+	}
+	return newTaskSchedulerError(op, code, description, err)
+}
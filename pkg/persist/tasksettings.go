@@ -0,0 +1,129 @@
+package persist
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// multipleInstancesPolicies maps setting values to TASK_INSTANCES_POLICY names.
+var multipleInstancesPolicies = map[string]string{
+	"parallel": "Parallel",
+	"queue":    "Queue",
+	"ignore":   "IgnoreNew",
+	"stop":     "StopExisting",
+}
+
+// DefaultTaskSettings returns the settings used when none are given: unlike
+// the Task Scheduler defaults, the task starts and keeps running on batteries.
+func DefaultTaskSettings() *TaskSettings {
+	no := false
+	return &TaskSettings{
+		DisallowStartIfOnBatteries: &no,
+		StopIfGoingOnBatteries:     &no,
+	}
+}
+
+// ParseTaskSettings parses a settings spec of white space separated
+// key=value pairs on top of DefaultTaskSettings, for example:
+//
+//	start-on-batteries=true stop-on-batteries=false start-when-available=true
+//	restart-count=3 restart-interval=5m limit=none instances=parallel
+//	network=true wake=true priority=4 hidden=true
+func ParseTaskSettings(spec string) (*TaskSettings, error) {
+	settings := DefaultTaskSettings()
+	fields, err := splitSpecFields(spec)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, field := range fields {
+		key, value, ok := strings.Cut(field, "=")
+		if !ok {
+			return nil, fmt.Errorf("setting %q is not in key=value form", field)
+		}
+		if err := applyTaskSetting(settings, strings.ToLower(key), value); err != nil {
+			return nil, fmt.Errorf("invalid setting %s: %w", key, err)
+		}
+	}
+
+	if settings.RestartOnFailure != nil {
+		if settings.RestartOnFailure.Count == 0 {
+			return nil, fmt.Errorf("restart-interval requires restart-count")
+		}
+		if settings.RestartOnFailure.Interval == "" {
+			settings.RestartOnFailure.Interval = "PT1M"
+		}
+	}
+	return settings, nil
+}
+
+// applyTaskSetting sets a single setting of a settings spec.
+func applyTaskSetting(settings *TaskSettings, key, value string) error {
+	var err error
+	switch key {
+	case "start-on-batteries":
+		var allow *bool
+		allow, err = boolSetting(value)
+		if err == nil {
+			disallow := !*allow
+			settings.DisallowStartIfOnBatteries = &disallow
+		}
+	case "stop-on-batteries":
+		settings.StopIfGoingOnBatteries, err = boolSetting(value)
+	case "start-when-available":
+		settings.StartWhenAvailable, err = boolSetting(value)
+	case "network":
+		settings.RunOnlyIfNetworkAvailable, err = boolSetting(value)
+	case "wake":
+		settings.WakeToRun, err = boolSetting(value)
+	case "hidden":
+		settings.Hidden, err = boolSetting(value)
+	case "restart-count":
+		var count int
+		count, err = strconv.Atoi(value)
+		if err != nil || count < 1 || count > 999 {
+			return fmt.Errorf("expected a number from 1 to 999, got %q", value)
+		}
+		if settings.RestartOnFailure == nil {
+			settings.RestartOnFailure = &RestartOnFailure{}
+		}
+		settings.RestartOnFailure.Count = count
+	case "restart-interval":
+		if settings.RestartOnFailure == nil {
+			settings.RestartOnFailure = &RestartOnFailure{}
+		}
+		settings.RestartOnFailure.Interval, err = parseTaskDuration(value)
+	case "limit":
+		if strings.EqualFold(value, "none") {
+			// PT0S lets the task run indefinitely.
+			settings.ExecutionTimeLimit = "PT0S"
+			return nil
+		}
+		settings.ExecutionTimeLimit, err = parseTaskDuration(value)
+	case "instances":
+		policy, ok := multipleInstancesPolicies[strings.ToLower(value)]
+		if !ok {
+			return fmt.Errorf("expected parallel, queue, ignore or stop, got %q", value)
+		}
+		settings.MultipleInstancesPolicy = policy
+	case "priority":
+		var priority int
+		priority, err = strconv.Atoi(value)
+		if err != nil || priority < 0 || priority > 10 {
+			return fmt.Errorf("expected a number from 0 (highest) to 10 (lowest), got %q", value)
+		}
+		settings.Priority = &priority
+	default:
+		return fmt.Errorf("unknown setting")
+	}
+	return err
+}
+
+func boolSetting(value string) (*bool, error) {
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return nil, fmt.Errorf("expected true or false, got %q", value)
+	}
+	return &b, nil
+}
@@ -0,0 +1,41 @@
+package persist
+
+import (
+	"fmt"
+	"time"
+)
+
+// taskStateNames maps TASK_STATE values to their names.
+var taskStateNames = map[int]string{
+	0: "Unknown",
+	1: "Disabled",
+	2: "Queued",
+	3: "Ready",
+	4: "Running",
+}
+
+// TaskStatus is the runtime state of a registered task.
+type TaskStatus struct {
+	Path               string
+	State              string
+	Enabled            bool
+	LastRunTime        time.Time
+	LastTaskResult     uint32
+	NextRunTime        time.Time
+	NumberOfMissedRuns int
+}
+
+// String formats the status for display.
+func (st *TaskStatus) String() string {
+	return fmt.Sprintf("Task '%s': state %s, enabled %t, last run %s (result 0x%08X), next run %s, missed runs %d",
+		st.Path, st.State, st.Enabled, formatTaskRunTime(st.LastRunTime), st.LastTaskResult, formatTaskRunTime(st.NextRunTime), st.NumberOfMissedRuns)
+}
+
+// formatTaskRunTime formats a run time, which Task Scheduler reports as the
+// zero OLE date (1899-12-30) if there is none.
+func formatTaskRunTime(t time.Time) string {
+	if t.Year() < 1900 {
+		return "never"
+	}
+	return t.Format(time.DateTime)
+}
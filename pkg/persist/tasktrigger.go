@@ -0,0 +1,459 @@
+package persist
+
+import (
+	"encoding/xml"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// taskTimeLayout is the local time format Task Scheduler uses for boundaries.
+const taskTimeLayout = "2006-01-02T15:04:05"
+
+// sessionStateChanges maps trigger names to SessionStateChangeTrigger states.
+var sessionStateChanges = map[string]string{
+	"lock":               "SessionLock",
+	"unlock":             "SessionUnlock",
+	"remote-connect":     "RemoteConnect",
+	"remote-disconnect":  "RemoteDisconnect",
+	"console-connect":    "ConsoleConnect",
+	"console-disconnect": "ConsoleDisconnect",
+}
+
+var weekdayNames = []string{"Sunday", "Monday", "Tuesday", "Wednesday", "Thursday", "Friday", "Saturday"}
+
+var monthNames = []string{"January", "February", "March", "April", "May", "June", "July", "August", "September", "October", "November", "December"}
+
+// isoDurationPattern matches xs:duration values such as PT15M or P1DT2H.
+var isoDurationPattern = regexp.MustCompile(`^P(\d+Y)?(\d+M)?(\d+W)?(\d+D)?(T(\d+H)?(\d+M)?(\d+S)?)?$`)
+
+// ParseTriggers parses a trigger spec into Task Scheduler triggers. The spec is
+// a ';' separated list of triggers, each a name followed by optional
+// key=value parameters, for example:
+//
+//	daily at=09:00; logon user=CONTOSO\bob delay=30s
+//	weekly days=mon,fri at=18:30 every=2
+//	monthly days=1,15,last months=jan,jul at=02:00
+//	once at=2025-06-01T12:00:00
+//	boot delay=1m; idle; registration; lock; unlock; remote-connect
+//	event channel=Security provider=Microsoft-Windows-Security-Auditing ids=4624,4625
+//	event channel=System query="*[System[EventID=7045]]"
+//
+// Parameters shared by all triggers are start, end, repeat, for, limit and
+// disabled. Time based triggers also accept at and random. A part can also be
+// a cron expression or an "every" schedule, see ParseSchedule.
+func ParseTriggers(spec string, now time.Time) ([]Trigger, error) {
+	var triggers []Trigger
+	for _, part := range splitTriggerSpec(spec) {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		if isScheduleExpression(part) {
+			scheduled, err := ParseSchedule(part, now)
+			if err != nil {
+				return nil, fmt.Errorf("invalid schedule %q: %w", part, err)
+			}
+			triggers = append(triggers, scheduled...)
+			continue
+		}
+		t, err := parseTrigger(part, now)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trigger %q: %w", part, err)
+		}
+		triggers = append(triggers, t)
+	}
+	if len(triggers) > maxTaskTriggers {
+		return nil, fmt.Errorf("%d triggers given but Task Scheduler allows at most %d", len(triggers), maxTaskTriggers)
+	}
+	return triggers, nil
+}
+
+// parseTrigger parses a single trigger of a trigger spec.
+func parseTrigger(spec string, now time.Time) (Trigger, error) {
+	fields, err := splitSpecFields(spec)
+	if err != nil {
+		return Trigger{}, err
+	}
+	name := strings.ToLower(fields[0])
+	params := map[string]string{}
+	for _, field := range fields[1:] {
+		key, value, ok := strings.Cut(field, "=")
+		if !ok {
+			return Trigger{}, fmt.Errorf("parameter %q is not in key=value form", field)
+		}
+		params[strings.ToLower(key)] = value
+	}
+
+	t, err := newTrigger(name, params, now)
+	if err != nil {
+		return Trigger{}, err
+	}
+	if err := applyCommonTriggerParams(&t, params, now); err != nil {
+		return Trigger{}, err
+	}
+	return t, nil
+}
+
+// splitTriggerSpec splits a trigger spec into its triggers on ; outside
+// quotes, so that quoted values such as event queries may contain ;. The
+// quotes are kept for splitSpecFields.
+func splitTriggerSpec(spec string) []string {
+	var parts []string
+	var quote rune
+	start := 0
+	for i, r := range spec {
+		switch {
+		case quote != 0 && r == quote:
+			quote = 0
+		case quote != 0:
+		case r == '\'' || r == '"':
+			quote = r
+		case r == ';':
+			parts = append(parts, spec[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, spec[start:])
+}
+
+// splitSpecFields splits a trigger or settings spec on white space. Values can be
+// quoted with ' or " to include white space, e.g. query="*[System[EventID=1 or EventID=2]]".
+func splitSpecFields(spec string) ([]string, error) {
+	var fields []string
+	var field strings.Builder
+	var quote rune
+	inField := false
+	for _, r := range spec {
+		switch {
+		case quote != 0 && r == quote:
+			quote = 0
+		case quote != 0:
+			field.WriteRune(r)
+		case r == '\'' || r == '"':
+			quote = r
+			inField = true
+		case r == ' ' || r == '\t':
+			if inField {
+				fields = append(fields, field.String())
+				field.Reset()
+				inField = false
+			}
+		default:
+			field.WriteRune(r)
+			inField = true
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated %c quote", quote)
+	}
+	if inField {
+		fields = append(fields, field.String())
+	}
+	return fields, nil
+}
+
+// newTrigger creates the trigger called name, consuming the parameters
+// specific to its type from params.
+func newTrigger(name string, params map[string]string, now time.Time) (Trigger, error) {
+	switch name {
+	case "daily":
+		days, err := intParam(params, "every", 1, 1, 365)
+		if err != nil {
+			return Trigger{}, err
+		}
+		t, err := newTimeTrigger("CalendarTrigger", params, now, now.Add(10*time.Hour))
+		t.ScheduleByDay = &ScheduleByDay{DaysInterval: days}
+		return t, err
+
+	case "hourly":
+		hours, err := intParam(params, "every", 1, 1, 23)
+		if err != nil {
+			return Trigger{}, err
+		}
+		t, err := newTimeTrigger("TimeTrigger", params, now, now)
+		t.Repetition = &Repetition{Interval: fmt.Sprintf("PT%dH", hours)}
+		return t, err
+
+	case "once":
+		at := params["at"]
+		t, err := newTimeTrigger("TimeTrigger", params, now, now.Add(time.Minute))
+		if err != nil {
+			return Trigger{}, err
+		}
+		// A bare time of day that already passed today means tomorrow, an
+		// explicit date in the past would never run.
+		if start, _ := time.ParseInLocation(taskTimeLayout, t.StartBoundary, now.Location()); start.Before(now) {
+			if _, err := time.Parse("15:04", at); err != nil {
+				return Trigger{}, fmt.Errorf("at %s is in the past", at)
+			}
+			t.StartBoundary = start.AddDate(0, 0, 1).Format(taskTimeLayout)
+		}
+		return t, nil
+
+	case "weekly":
+		weeks, err := intParam(params, "every", 1, 1, 52)
+		if err != nil {
+			return Trigger{}, err
+		}
+		days, err := parseNames(take(params, "days"), weekdayNames, weekdayNames[now.Weekday()])
+		if err != nil {
+			return Trigger{}, err
+		}
+		t, err := newTimeTrigger("CalendarTrigger", params, now, now.Add(10*time.Hour))
+		t.ScheduleByWeek = &ScheduleByWeek{WeeksInterval: weeks, DaysOfWeek: &NameList{Names: days}}
+		return t, err
+
+	case "monthly":
+		days, err := parseDaysOfMonth(take(params, "days"), now.Day())
+		if err != nil {
+			return Trigger{}, err
+		}
+		months, err := parseNames(take(params, "months"), monthNames, "")
+		if err != nil {
+			return Trigger{}, err
+		}
+		t, err := newTimeTrigger("CalendarTrigger", params, now, now.Add(10*time.Hour))
+		t.ScheduleByMonth = &ScheduleByMonth{DaysOfMonth: &DaysOfMonth{Days: days}, Months: &NameList{Names: months}}
+		return t, err
+
+	case "logon":
+		return Trigger{XMLName: xml.Name{Local: "LogonTrigger"}, UserID: take(params, "user")}, nil
+
+	case "boot":
+		return Trigger{XMLName: xml.Name{Local: "BootTrigger"}}, nil
+
+	case "idle":
+		return Trigger{XMLName: xml.Name{Local: "IdleTrigger"}}, nil
+
+	case "registration":
+		return Trigger{XMLName: xml.Name{Local: "RegistrationTrigger"}}, nil
+
+	case "event":
+		return newEventTrigger(params)
+	}
+
+	state, ok := sessionStateChanges[name]
+	if !ok {
+		return Trigger{}, fmt.Errorf("unknown trigger %q", name)
+	}
+	return Trigger{XMLName: xml.Name{Local: "SessionStateChangeTrigger"}, StateChange: state, UserID: take(params, "user")}, nil
+}
+
+// newTimeTrigger creates a time based trigger whose start boundary comes from
+// the at parameter, or def if it is not given. Calendar triggers default to
+// ten hours from now, as the original daily trigger did.
+func newTimeTrigger(kind string, params map[string]string, now, def time.Time) (Trigger, error) {
+	start := def
+	if value := take(params, "at"); value != "" {
+		var err error
+		start, err = parseTaskTime(value, now)
+		if err != nil {
+			return Trigger{}, fmt.Errorf("invalid at: %w", err)
+		}
+	}
+
+	t := Trigger{
+		XMLName:       xml.Name{Local: kind},
+		StartBoundary: start.Format(taskTimeLayout),
+	}
+	if value := take(params, "random"); value != "" {
+		d, err := parseTaskDuration(value)
+		if err != nil {
+			return Trigger{}, fmt.Errorf("invalid random: %w", err)
+		}
+		t.RandomDelay = d
+	}
+	return t, nil
+}
+
+// take returns the parameter key and removes it from params.
+func take(params map[string]string, key string) string {
+	value := params[key]
+	delete(params, key)
+	return value
+}
+
+// applyCommonTriggerParams applies the parameters shared by all trigger
+// types and rejects any parameter left over.
+func applyCommonTriggerParams(t *Trigger, params map[string]string, now time.Time) error {
+	for key, value := range params {
+		var err error
+		switch key {
+		case "start", "end":
+			var ts time.Time
+			ts, err = parseTaskTime(value, now)
+			if key == "start" {
+				t.StartBoundary = ts.Format(taskTimeLayout)
+			} else {
+				t.EndBoundary = ts.Format(taskTimeLayout)
+			}
+		case "delay":
+			if t.Kind() == "TimeTrigger" || t.Kind() == "CalendarTrigger" || t.Kind() == "IdleTrigger" {
+				return fmt.Errorf("parameter delay is not supported by %s", t.Kind())
+			}
+			t.Delay, err = parseTaskDuration(value)
+		case "repeat":
+			if t.Repetition == nil {
+				t.Repetition = &Repetition{}
+			}
+			t.Repetition.Interval, err = parseTaskDuration(value)
+		case "for":
+			if t.Repetition == nil {
+				t.Repetition = &Repetition{}
+			}
+			t.Repetition.Duration, err = parseTaskDuration(value)
+		case "limit":
+			t.ExecutionTimeLimit, err = parseTaskDuration(value)
+		case "disabled":
+			var disabled bool
+			disabled, err = strconv.ParseBool(value)
+			enabled := !disabled
+			t.Enabled = &enabled
+		default:
+			return fmt.Errorf("parameter %s is not supported by %s", key, t.Kind())
+		}
+		if err != nil {
+			return fmt.Errorf("invalid %s: %w", key, err)
+		}
+	}
+
+	if t.Repetition != nil && t.Repetition.Interval == "" {
+		return fmt.Errorf("parameter for requires repeat")
+	}
+	return nil
+}
+
+// parseTaskTime parses a time of day (15:04) for today, or a full date and
+// time (2006-01-02T15:04[:05]), both in the location of now.
+func parseTaskTime(value string, now time.Time) (time.Time, error) {
+	for _, layout := range []string{taskTimeLayout, "2006-01-02T15:04", "2006-01-02 15:04"} {
+		if ts, err := time.ParseInLocation(layout, value, now.Location()); err == nil {
+			return ts, nil
+		}
+	}
+
+	clock, err := time.Parse("15:04", value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("expected HH:MM or YYYY-MM-DDTHH:MM[:SS], got %q", value)
+	}
+	y, m, d := now.Date()
+	return time.Date(y, m, d, clock.Hour(), clock.Minute(), 0, 0, now.Location()), nil
+}
+
+// parseTaskDuration accepts either an xs:duration (PT15M) or a Go duration
+// (15m, 1h30m) and returns it as an xs:duration.
+func parseTaskDuration(value string) (string, error) {
+	if upper := strings.ToUpper(value); isoDurationPattern.MatchString(upper) && upper != "P" && upper != "PT" {
+		return upper, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return "", fmt.Errorf("expected a duration such as 15m or PT15M, got %q", value)
+	}
+	if d <= 0 {
+		return "", fmt.Errorf("duration must be positive, got %q", value)
+	}
+	return formatTaskDuration(d), nil
+}
+
+// formatTaskDuration formats a duration as an xs:duration, e.g. PT1H30M.
+func formatTaskDuration(d time.Duration) string {
+	d = d.Round(time.Second)
+	days := d / (24 * time.Hour)
+	d -= days * 24 * time.Hour
+	hours := d / time.Hour
+	d -= hours * time.Hour
+	minutes := d / time.Minute
+	d -= minutes * time.Minute
+	seconds := d / time.Second
+
+	out := "P"
+	if days > 0 {
+		out += fmt.Sprintf("%dD", days)
+	}
+	if hours > 0 || minutes > 0 || seconds > 0 {
+		out += "T"
+		if hours > 0 {
+			out += fmt.Sprintf("%dH", hours)
+		}
+		if minutes > 0 {
+			out += fmt.Sprintf("%dM", minutes)
+		}
+		if seconds > 0 {
+			out += fmt.Sprintf("%dS", seconds)
+		}
+	}
+	if out == "P" {
+		out = "PT0S"
+	}
+	return out
+}
+
+// intParam takes the integer parameter key from params, or returns def if it
+// is not set.
+func intParam(params map[string]string, key string, def, min, max int) (int, error) {
+	value := take(params, key)
+	if value == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < min || n > max {
+		return 0, fmt.Errorf("invalid %s: expected a number from %d to %d, got %q", key, min, max, value)
+	}
+	return n, nil
+}
+
+// parseNames parses a comma separated list of day or month names, matching
+// on unambiguous prefixes of at least three letters. An empty list yields def,
+// or every name if def is empty.
+func parseNames(value string, names []string, def string) ([]string, error) {
+	if value == "" {
+		if def != "" {
+			return []string{def}, nil
+		}
+		return append([]string(nil), names...), nil
+	}
+
+	var out []string
+	for _, item := range strings.Split(value, ",") {
+		item = strings.ToLower(strings.TrimSpace(item))
+		match := ""
+		for _, name := range names {
+			if len(item) >= 3 && strings.HasPrefix(strings.ToLower(name), item) {
+				match = name
+				break
+			}
+		}
+		if match == "" {
+			return nil, fmt.Errorf("unknown name %q, expected one of %s", item, strings.Join(names, ", "))
+		}
+		out = append(out, match)
+	}
+	return out, nil
+}
+
+// parseDaysOfMonth parses a comma separated list of days of the month
+// (1-31 or last). An empty list yields def.
+func parseDaysOfMonth(value string, def int) ([]string, error) {
+	if value == "" {
+		return []string{strconv.Itoa(def)}, nil
+	}
+
+	var out []string
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if strings.EqualFold(item, "last") {
+			out = append(out, "Last")
+			continue
+		}
+		n, err := strconv.Atoi(item)
+		if err != nil || n < 1 || n > 31 {
+			return nil, fmt.Errorf("invalid day of month %q, expected 1-31 or last", item)
+		}
+		out = append(out, strconv.Itoa(n))
+	}
+	return out, nil
+}
@@ -0,0 +1,57 @@
+package persist
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseTriggersOnce(t *testing.T) {
+	now := time.Date(2026, 3, 2, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		spec    string
+		want    string
+		wantErr bool
+	}{
+		{"once at=13:00", "2026-03-02T13:00:00", false},
+		// A time of day that already passed today rolls over to tomorrow
+		{"once at=09:00", "2026-03-03T09:00:00", false},
+		{"once at=2026-03-05T09:00", "2026-03-05T09:00:00", false},
+		// An explicit date in the past is rejected, not moved
+		{"once at=2026-03-01T09:00", "", true},
+		{"once at=2026-03-02T09:00", "", true},
+	}
+	for _, tt := range tests {
+		triggers, err := ParseTriggers(tt.spec, now)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("ParseTriggers(%q) = %+v, want error", tt.spec, triggers)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseTriggers(%q): %v", tt.spec, err)
+			continue
+		}
+		if got := triggers[0].StartBoundary; got != tt.want {
+			t.Errorf("ParseTriggers(%q) start = %s, want %s", tt.spec, got, tt.want)
+		}
+	}
+}
+
+func TestParseTriggersQuotedSemicolon(t *testing.T) {
+	now := time.Date(2026, 3, 2, 12, 0, 0, 0, time.UTC)
+	triggers, err := ParseTriggers(`event channel=Application query="*[EventData[Data='a;b']]"; logon`, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(triggers) != 2 {
+		t.Fatalf("got %d triggers, want 2", len(triggers))
+	}
+	if triggers[0].Kind() != "EventTrigger" || !strings.Contains(triggers[0].Subscription, "a;b") {
+		t.Errorf("event trigger = %+v, want the query with a;b", triggers[0])
+	}
+	if triggers[1].Kind() != "LogonTrigger" {
+		t.Errorf("second trigger = %s, want LogonTrigger", triggers[1].Kind())
+	}
+}
@@ -0,0 +1,260 @@
+package persist
+
+import (
+	"encoding/xml"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	taskSchemaVersion   = "1.2"
+	taskSchemaNamespace = "http://schemas.microsoft.com/windows/2004/02/mit/task"
+)
+
+// SchTask describes a scheduled task to create, remove or check.
+type SchTask struct {
+	taskName string
+	command  string
+	args     []string
+	trigger  string
+
+	// imported is set when the task definition comes from an XML file.
+	imported *TaskDefinition
+	// importedXML is the imported document as read, decoded to UTF-8. It is
+	// registered as is unless the task is changed, since TaskDefinition does
+	// not model every element of the schema.
+	importedXML string
+	// principal is the security context of the task, nil for the current user.
+	principal *TaskPrincipal
+	// settings overrides DefaultTaskSettings when set.
+	settings *TaskSettings
+	// workingDir is the working directory of the command.
+	workingDir string
+	// actions run in order after the command.
+	actions []Action
+	// removeFolders makes RemoveTask delete empty folders GoPersist created.
+	removeFolders bool
+	// start is the reference time used for time based triggers.
+	start time.Time
+}
+
+// NewSchTask creates a SchTask that runs command with args. The arguments are
+// quoted with JoinArgs.
+func NewSchTask(taskName, command string, args []string, trigger string) *SchTask {
+	return &SchTask{
+		taskName: taskName,
+		command:  command,
+		args:     args,
+		trigger:  trigger,
+		start:    time.Now(),
+	}
+}
+
+// NewSchTaskFromXML creates a SchTask whose definition is imported from an
+// existing Task Scheduler XML file, such as one exported by schtasks /query /xml.
+func NewSchTaskFromXML(taskName, xmlPath string) (*SchTask, error) {
+	data, err := os.ReadFile(xmlPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to import task XML %s: %w", xmlPath, err)
+	}
+	def, err := ParseTaskXML(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to import task XML %s: %w", xmlPath, err)
+	}
+
+	return &SchTask{
+		taskName:    taskName,
+		imported:    def,
+		importedXML: string(decodeTaskXMLBytes(data)),
+		start:       time.Now(),
+	}, nil
+}
+
+// SetPrincipal sets the account and run level the task runs under.
+func (s *SchTask) SetPrincipal(p *TaskPrincipal) {
+	s.principal = p
+}
+
+// SetSettings sets the settings that control how Task Scheduler runs the
+// task, replacing DefaultTaskSettings or the settings of an imported task.
+func (s *SchTask) SetSettings(settings *TaskSettings) {
+	s.settings = settings
+}
+
+// SetWorkingDirectory sets the working directory of the command.
+func (s *SchTask) SetWorkingDirectory(dir string) {
+	s.workingDir = dir
+}
+
+// AddAction appends an action that runs after the command and any actions
+// added before it, or after the actions of an imported task.
+func (s *SchTask) AddAction(action Action) {
+	s.actions = append(s.actions, action)
+}
+
+// SetRemoveEmptyFolders makes RemoveTask delete the folders of the task that
+// GoPersist created, once they are empty.
+func (s *SchTask) SetRemoveEmptyFolders(remove bool) {
+	s.removeFolders = remove
+}
+
+// Principal returns the principal the task is registered with: the one set
+// with SetPrincipal, else the one of an imported definition, else nil for the
+// current user.
+func (s *SchTask) Principal() *TaskPrincipal {
+	if s.principal != nil {
+		return s.principal
+	}
+	if s.imported != nil && len(s.imported.PrincipalList()) > 0 {
+		return principalFromXML(s.imported.PrincipalList()[0])
+	}
+	return nil
+}
+
+// Definition builds the task definition that CreateTask registers. For an
+// imported task registered unchanged, XML uses the imported document instead.
+func (s *SchTask) Definition() (*TaskDefinition, error) {
+	folder, name, err := splitTaskPath(s.taskName)
+	if err != nil {
+		return nil, err
+	}
+
+	if s.imported != nil {
+		def := *s.imported
+		def.Version = taskSchemaVersion
+		def.Xmlns = taskSchemaNamespace
+		info := RegistrationInfo{}
+		if def.RegistrationInfo != nil {
+			info = *def.RegistrationInfo
+		}
+		info.URI = taskPath(folder, name)
+		def.RegistrationInfo = &info
+		if s.principal != nil {
+			s.setPrincipal(&def)
+		}
+		if s.settings != nil {
+			def.Settings = s.settings
+		}
+		def.Actions.Items = append(append([]Action(nil), def.Actions.Items...), s.actions...)
+		if err := checkActions(def.Actions.Items); err != nil {
+			return nil, err
+		}
+		return &def, nil
+	}
+
+	def := &TaskDefinition{
+		Version: taskSchemaVersion,
+		Xmlns:   taskSchemaNamespace,
+		RegistrationInfo: &RegistrationInfo{
+			Author: "GoPersist",
+			URI:    taskPath(folder, name),
+		},
+	}
+
+	// Set Task Trigger based on options
+	triggers, err := ParseTriggers(s.trigger, s.start)
+	if err != nil {
+		return nil, err
+	}
+	if len(triggers) > 0 {
+		def.Triggers = &Triggers{Items: triggers}
+	}
+
+	// Set Principal
+	if s.principal != nil {
+		s.setPrincipal(def)
+	}
+
+	// Set Task Settings
+	def.Settings = s.settings
+	if def.Settings == nil {
+		def.Settings = DefaultTaskSettings()
+	}
+
+	// Set Actions
+	if s.command != "" {
+		def.Actions.Items = append(def.Actions.Items, NewExecAction(s.command, JoinArgs(s.args), s.workingDir))
+	}
+	def.Actions.Items = append(def.Actions.Items, s.actions...)
+	if err := checkActions(def.Actions.Items); err != nil {
+		return nil, err
+	}
+
+	return def, nil
+}
+
+// checkActions checks that a task has between one and maxTaskActions actions.
+func checkActions(actions []Action) error {
+	if len(actions) == 0 {
+		return fmt.Errorf("a task needs at least one action")
+	}
+	if len(actions) > maxTaskActions {
+		return fmt.Errorf("%d actions given but Task Scheduler allows at most %d", len(actions), maxTaskActions)
+	}
+	return nil
+}
+
+// setPrincipal replaces the principals of def with the task principal and
+// runs the actions in its context.
+func (s *SchTask) setPrincipal(def *TaskDefinition) {
+	principal := s.principal.xml()
+	def.Principals = &Principals{Items: []Principal{principal}}
+	def.Actions.Context = principal.ID
+}
+
+// XML renders the task definition as a Task Scheduler 1.2 XML document,
+// without the XML declaration.
+func (s *SchTask) XML() (string, error) {
+	def, err := s.Definition()
+	if err != nil {
+		return "", err
+	}
+
+	// Register an unchanged import as read, so that elements TaskDefinition
+	// does not model are kept
+	if s.importedXML != "" && s.principal == nil && s.settings == nil && len(s.actions) == 0 {
+		return patchTaskURI(s.importedXML, def.RegistrationInfo.URI)
+	}
+
+	out, err := xml.MarshalIndent(def, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("failed to render task XML: %w", err)
+	}
+	return string(out), nil
+}
+
+var (
+	taskXMLDeclaration  = regexp.MustCompile(`^\s*<\?xml[^?]*\?>\s*`)
+	taskURIElement      = regexp.MustCompile(`(?s)<URI>.*?</URI>|<URI\s*/>`)
+	taskRegistrationTag = regexp.MustCompile(`<RegistrationInfo\s*/>|<RegistrationInfo>`)
+	taskStartTag        = regexp.MustCompile(`<Task\b[^>]*>`)
+)
+
+// patchTaskURI sets the URI of the task document doc, leaving everything else
+// as it is, and strips its XML declaration, whose encoding no longer applies.
+func patchTaskURI(doc, uri string) (string, error) {
+	var escaped strings.Builder
+	if err := xml.EscapeText(&escaped, []byte(uri)); err != nil {
+		return "", err
+	}
+	element := "<URI>" + escaped.String() + "</URI>"
+
+	doc = taskXMLDeclaration.ReplaceAllString(doc, "")
+	if loc := taskURIElement.FindStringIndex(doc); loc != nil {
+		return doc[:loc[0]] + element + doc[loc[1]:], nil
+	}
+	if loc := taskRegistrationTag.FindStringIndex(doc); loc != nil {
+		info := "<RegistrationInfo>" + element
+		if strings.HasSuffix(doc[loc[0]:loc[1]], "/>") {
+			info += "</RegistrationInfo>"
+		}
+		return doc[:loc[0]] + info + doc[loc[1]:], nil
+	}
+	if loc := taskStartTag.FindStringIndex(doc); loc != nil {
+		return doc[:loc[1]] + "<RegistrationInfo>" + element + "</RegistrationInfo>" + doc[loc[1]:], nil
+	}
+	return "", fmt.Errorf("task XML has no Task element")
+}
@@ -0,0 +1,234 @@
+package persist
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var updateGolden = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// goldenStart is the reference time of the golden tasks.
+var goldenStart = time.Date(2026, 3, 2, 8, 0, 0, 0, time.UTC)
+
+// checkGolden compares got with testdata/name, or rewrites the file with
+// -update.
+func checkGolden(t *testing.T, name, got string) {
+	t.Helper()
+	path := filepath.Join("testdata", name)
+	if *updateGolden {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(got), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("%v (run go test -update to create it)", err)
+	}
+	if got != string(want) {
+		t.Errorf("%s differs from the golden file:\ngot:\n%s\nwant:\n%s", name, got, want)
+	}
+}
+
+func mustPrincipal(t *testing.T, user, group, password, logon, runLevel string) *TaskPrincipal {
+	t.Helper()
+	p, err := NewTaskPrincipal(user, group, password, logon, runLevel)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func mustSettings(t *testing.T, spec string) *TaskSettings {
+	t.Helper()
+	settings, err := ParseTaskSettings(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return settings
+}
+
+func mustAction(t *testing.T, spec string) Action {
+	t.Helper()
+	a, err := ParseTaskAction(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a
+}
+
+func TestSchTaskXMLGolden(t *testing.T) {
+	tests := []struct {
+		name string
+		task func(t *testing.T) *SchTask
+	}{
+		{"daily.xml", func(t *testing.T) *SchTask {
+			return NewSchTask(`\GoPersist\Daily`, `C:\Program Files\Tool\tool.exe`, []string{"--out", `C:\My Logs\run.log`, `say "hi"`}, "daily at=09:30")
+		}},
+		{"system-boot.xml", func(t *testing.T) *SchTask {
+			task := NewSchTask("Boot", `C:\Windows\System32\cmd.exe`, []string{"/c", "whoami"}, "boot delay=1m")
+			task.SetPrincipal(mustPrincipal(t, "SYSTEM", "", "", "", "highest"))
+			task.SetSettings(mustSettings(t, "hidden=true restart-count=3 restart-interval=5m limit=none"))
+			return task
+		}},
+		{"multi-trigger-actions.xml", func(t *testing.T) *SchTask {
+			task := NewSchTask(`Acme\Updater`, `C:\Acme\update.exe`, nil, "logon delay=30s; weekly days=mon,fri at=18:30 every=2; */15 9-17 * * 1-5")
+			task.SetWorkingDirectory(`C:\Acme`)
+			task.AddAction(mustAction(t, `exec cmd=C:\Windows\System32\cmd.exe args="/c echo done"`))
+			task.AddAction(mustAction(t, "com class={0F87369F-A4E5-4CFC-BD3E-73E6154572DD} data=start"))
+			return task
+		}},
+		{"event-group.xml", func(t *testing.T) *SchTask {
+			task := NewSchTask(`\Watch`, `C:\Tools\watch.exe`, nil, "event channel=System ids=7045,7036")
+			task.SetPrincipal(mustPrincipal(t, "", `BUILTIN\Users`, "", "", ""))
+			return task
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			task := tt.task(t)
+			task.start = goldenStart
+			got, err := task.XML()
+			if err != nil {
+				t.Fatalf("XML: %v", err)
+			}
+			checkGolden(t, tt.name, got)
+
+			// The rendered XML parses back to the same definition
+			def, err := task.Definition()
+			if err != nil {
+				t.Fatal(err)
+			}
+			parsed, err := ParseTaskXML([]byte(got))
+			if err != nil {
+				t.Fatalf("ParseTaskXML: %v", err)
+			}
+			if len(parsed.TriggerList()) != len(def.TriggerList()) || len(parsed.Actions.Items) != len(def.Actions.Items) {
+				t.Errorf("round trip lost triggers or actions")
+			}
+		})
+	}
+}
+
+// importedTaskXML uses elements TaskDefinition does not model.
+const importedTaskXML = `<?xml version="1.0" encoding="UTF-16"?>
+<Task version="1.4" xmlns="http://schemas.microsoft.com/windows/2004/02/mit/task">
+  <RegistrationInfo>
+    <Author>CONTOSO\admin</Author>
+    <URI>\Original\Name</URI>
+  </RegistrationInfo>
+  <Triggers>
+    <CalendarTrigger>
+      <StartBoundary>2024-01-01T03:00:00</StartBoundary>
+      <ScheduleByMonthDayOfWeek>
+        <Weeks><Week>Last</Week></Weeks>
+        <DaysOfWeek><Friday /></DaysOfWeek>
+        <Months><March /></Months>
+      </ScheduleByMonthDayOfWeek>
+    </CalendarTrigger>
+    <EventTrigger>
+      <Subscription>&lt;QueryList&gt;&lt;/QueryList&gt;</Subscription>
+      <ValueQueries>
+        <Value name="id">Event/System/EventID</Value>
+      </ValueQueries>
+    </EventTrigger>
+  </Triggers>
+  <Principals>
+    <Principal id="Author">
+      <UserId>S-1-5-19</UserId>
+      <RequiredPrivileges>
+        <Privilege>SeBackupPrivilege</Privilege>
+      </RequiredPrivileges>
+      <ProcessTokenSidType>Unrestricted</ProcessTokenSidType>
+    </Principal>
+  </Principals>
+  <Settings>
+    <DisallowStartOnRemoteAppSession>true</DisallowStartOnRemoteAppSession>
+    <UseUnifiedSchedulingEngine>true</UseUnifiedSchedulingEngine>
+  </Settings>
+  <Actions Context="Author">
+    <Exec>
+      <Command>C:\Windows\System32\cleanmgr.exe</Command>
+    </Exec>
+  </Actions>
+</Task>
+`
+
+// importTask writes doc as a UTF-16LE task file and imports it as name.
+func importTask(t *testing.T, name, doc string) *SchTask {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "task.xml")
+	if err := os.WriteFile(path, utf16LEWithBOM(doc), 0644); err != nil {
+		t.Fatal(err)
+	}
+	task, err := NewSchTaskFromXML(name, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return task
+}
+
+func TestSchTaskXMLImportedGolden(t *testing.T) {
+	// Unchanged imports keep every element, only the URI is patched
+	got, err := importTask(t, `\Imported\Cleanup`, importedTaskXML).XML()
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkGolden(t, "imported-raw.xml", got)
+	for _, element := range []string{"ScheduleByMonthDayOfWeek", "ValueQueries", "RequiredPrivileges", "ProcessTokenSidType", "DisallowStartOnRemoteAppSession", "UseUnifiedSchedulingEngine"} {
+		if !strings.Contains(got, "<"+element+">") {
+			t.Errorf("unchanged import lost <%s>", element)
+		}
+	}
+
+	// Overrides re-render the modelled definition
+	task := importTask(t, `\Imported\Cleanup`, importedTaskXML)
+	task.SetSettings(mustSettings(t, "hidden=true"))
+	got, err = task.XML()
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkGolden(t, "imported-settings.xml", got)
+}
+
+func TestPatchTaskURI(t *testing.T) {
+	tests := []struct {
+		doc, want string
+	}{
+		{`<Task><RegistrationInfo><URI>\Old</URI></RegistrationInfo></Task>`, `<Task><RegistrationInfo><URI>\A&amp;B</URI></RegistrationInfo></Task>`},
+		{`<Task><RegistrationInfo><Author>x</Author></RegistrationInfo></Task>`, `<Task><RegistrationInfo><URI>\A&amp;B</URI><Author>x</Author></RegistrationInfo></Task>`},
+		{`<Task><RegistrationInfo/></Task>`, `<Task><RegistrationInfo><URI>\A&amp;B</URI></RegistrationInfo></Task>`},
+		{`<?xml version="1.0" encoding="UTF-16"?>` + "\n" + `<Task version="1.2"><Actions/></Task>`, `<Task version="1.2"><RegistrationInfo><URI>\A&amp;B</URI></RegistrationInfo><Actions/></Task>`},
+	}
+	for _, tt := range tests {
+		got, err := patchTaskURI(tt.doc, `\A&B`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("patchTaskURI(%q)\n got %q\nwant %q", tt.doc, got, tt.want)
+		}
+	}
+}
+
+func TestImportedPasswordPrincipal(t *testing.T) {
+	doc := strings.Replace(importedTaskXML, "<UserId>S-1-5-19</UserId>", `<UserId>CONTOSO\svc-backup</UserId><LogonType>Password</LogonType>`, 1)
+	task := importTask(t, `\Imported\Backup`, doc)
+	if err := task.Principal().checkPassword(); err == nil {
+		t.Error("imported password logon without a password was accepted")
+	}
+
+	task.SetPrincipal(mustPrincipal(t, `CONTOSO\svc-backup`, "", "secret", "password", ""))
+	if err := task.Principal().checkPassword(); err != nil {
+		t.Errorf("password given with SetPrincipal: %v", err)
+	}
+}